@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// authToken is the token required on protected endpoints, configured in main
+// Authentication is disabled when it is empty
+var authToken string
+
+// calendarUsername and calendarPassword are sent as Basic Auth when fetching a CALENDAR_URL that is
+// a single URL, configured in main. They are never sent to calendar URLs supplied with a request,
+// nor to named calendars, which take theirs from calendarCredentialsEnv
+var (
+	calendarUsername string
+	calendarPassword string
+)
+
+// calendarCredentialsEnv returns the environment variables holding the Basic Auth credentials
+// for the calendar with the given name, e.g. CALENDAR_USERNAME_WORK and CALENDAR_PASSWORD_WORK
+func calendarCredentialsEnv(name string) (string, string) {
+	suffix := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	return "CALENDAR_USERNAME_" + suffix, "CALENDAR_PASSWORD_" + suffix
+}
+
+// calendarCredentials returns the Basic Auth credentials for calendarURL (already normalized),
+// if it is a configured calendar that has some
+// Each calendar only gets its own credentials, so a password for one host is never sent to another
+func calendarCredentials(calendarURL string) (string, string, bool) {
+	calendars, err := getCalendarURLs()
+	if err != nil {
+		return "", "", false
+	}
+	for name, configured := range calendars {
+		if normalizeCalendarURL(configured) != calendarURL {
+			continue
+		}
+		if name == defaultCalendarName && calendarUsername != "" {
+			return calendarUsername, calendarPassword, true
+		}
+		usernameKey, passwordKey := calendarCredentialsEnv(name)
+		if username := getEnv(usernameKey, ""); username != "" {
+			return username, getEnv(passwordKey, ""), true
+		}
+	}
+	return "", "", false
+}
+
+// requestToken returns the token supplied with a request, from the Authorization header or the token parameter
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// requireToken rejects requests without a matching token when AUTH_TOKEN is set
+func requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authToken != "" {
+			token := requestToken(r)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(authToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="calendar-filter"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBreakerThreshold is how many consecutive failed fetches of a calendar open its circuit
+	defaultBreakerThreshold = 5
+	// defaultBreakerCooldown is how long an open circuit short-circuits fetches before trying again
+	defaultBreakerCooldown = 30 * time.Second
+	// maxCircuits bounds how many calendars' failures are tracked, since any request can name a
+	// new url=; the circuit that failed least recently is dropped to make room
+	maxCircuits = 100
+	// circuitIdleTimeout drops circuits that haven't failed for this long
+	circuitIdleTimeout = 24 * time.Hour
+)
+
+// circuitOpenError is returned instead of fetching a calendar whose circuit is open
+type circuitOpenError struct {
+	failures int
+	until    time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("upstream failed %d times in a row; not fetching again until %s", e.failures, e.until.Format(time.RFC3339))
+}
+
+// circuitState tracks the recent fetch failures of one calendar URL
+type circuitState struct {
+	failures    int
+	openUntil   time.Time
+	lastFailure time.Time
+}
+
+// circuitBreaker stops fetching a calendar for a cooldown once it has failed threshold times in a row,
+// so a failing upstream isn't retried on every request. Once the cooldown passes the next fetch goes
+// through, and another failure reopens the circuit straight away. A threshold of zero disables it
+// At most maxCircuits calendars are tracked, and one that hasn't failed for circuitIdleTimeout is forgotten
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	circuits  map[string]*circuitState
+}
+
+// fetchBreaker guards upstream calendar fetches, configured in main
+var fetchBreaker = newCircuitBreaker(defaultBreakerThreshold, defaultBreakerCooldown)
+
+// newCircuitBreaker creates a breaker that opens after threshold consecutive failures for cooldown
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		circuits:  make(map[string]*circuitState),
+	}
+}
+
+// allow returns a circuitOpenError if fetches of calendarURL are currently short-circuited
+func (b *circuitBreaker) allow(calendarURL string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.circuits[calendarURL]
+	if ok && time.Since(state.lastFailure) > circuitIdleTimeout {
+		delete(b.circuits, calendarURL)
+		return nil
+	}
+	if !ok || !time.Now().Before(state.openUntil) {
+		return nil
+	}
+	return &circuitOpenError{failures: state.failures, until: state.openUntil}
+}
+
+// record notes the outcome of fetching calendarURL
+// Only failures that suggest a flaky upstream (network errors and 5xx responses) count towards
+// the threshold; any success closes the circuit
+func (b *circuitBreaker) record(calendarURL string, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		delete(b.circuits, calendarURL)
+		return
+	}
+	if !isRetryableFetchError(err) {
+		return
+	}
+	now := time.Now()
+	state, ok := b.circuits[calendarURL]
+	if !ok {
+		b.makeRoom(now)
+		state = &circuitState{}
+		b.circuits[calendarURL] = state
+	}
+	state.failures++
+	state.lastFailure = now
+	if state.failures >= b.threshold {
+		state.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// makeRoom drops idle circuits and, if the breaker is still full, the one that failed least
+// recently, so a new circuit can be added; b.mu must be held
+func (b *circuitBreaker) makeRoom(now time.Time) {
+	leastRecentURL := ""
+	var leastRecent time.Time
+	for calendarURL, state := range b.circuits {
+		if now.Sub(state.lastFailure) > circuitIdleTimeout {
+			delete(b.circuits, calendarURL)
+			continue
+		}
+		if leastRecentURL == "" || state.lastFailure.Before(leastRecent) {
+			leastRecentURL, leastRecent = calendarURL, state.lastFailure
+		}
+	}
+	if len(b.circuits) >= maxCircuits {
+		delete(b.circuits, leastRecentURL)
+	}
+}
+
+// getBreakerThreshold returns the failure threshold from the CIRCUIT_BREAKER_THRESHOLD environment variable
+// Zero disables the circuit breaker
+func getBreakerThreshold() (int, error) {
+	valueStr := getEnv("CIRCUIT_BREAKER_THRESHOLD", "")
+	if valueStr == "" {
+		return defaultBreakerThreshold, nil
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid CIRCUIT_BREAKER_THRESHOLD: %s (expected a number of failures, or 0 to disable)", valueStr)
+	}
+	return value, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	breaker := newCircuitBreaker(2, time.Minute)
+	failure := &statusError{code: 503}
+
+	breaker.record("http://example.com/cal.ics", failure)
+	if err := breaker.allow("http://example.com/cal.ics"); err != nil {
+		t.Fatalf("circuit open after one failure: %v", err)
+	}
+	breaker.record("http://example.com/cal.ics", failure)
+	if err := breaker.allow("http://example.com/cal.ics"); err == nil {
+		t.Fatal("circuit still closed after reaching the threshold")
+	}
+	breaker.record("http://example.com/cal.ics", nil)
+	if err := breaker.allow("http://example.com/cal.ics"); err != nil {
+		t.Errorf("circuit open after a success: %v", err)
+	}
+}
+
+func TestCircuitBreakerBoundsCircuits(t *testing.T) {
+	breaker := newCircuitBreaker(1, time.Minute)
+	failure := &statusError{code: 503}
+
+	breaker.circuits["http://example.com/idle"] = &circuitState{
+		failures:    1,
+		openUntil:   time.Now().Add(time.Minute),
+		lastFailure: time.Now().Add(-2 * circuitIdleTimeout),
+	}
+	if err := breaker.allow("http://example.com/idle"); err != nil {
+		t.Errorf("idle circuit still open: %v", err)
+	}
+	if _, ok := breaker.circuits["http://example.com/idle"]; ok {
+		t.Error("idle circuit was not dropped")
+	}
+
+	for i := 0; i < maxCircuits+10; i++ {
+		breaker.record(fmt.Sprintf("http://example.com/%d", i), failure)
+	}
+	if len(breaker.circuits) != maxCircuits {
+		t.Errorf("breaker tracks %d circuits, want %d", len(breaker.circuits), maxCircuits)
+	}
+	if _, ok := breaker.circuits[fmt.Sprintf("http://example.com/%d", maxCircuits+9)]; !ok {
+		t.Error("newest circuit is missing")
+	}
+}
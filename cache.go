@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// maxCacheEntries bounds how many calendars are cached, since any request can name a new
+	// url=; the least recently used entry is evicted to make room
+	maxCacheEntries = 100
+	// cacheIdleTimeout drops entries no request has used for this long, such as one-off url= fetches
+	cacheIdleTimeout = 24 * time.Hour
+)
+
+// cacheEntry holds a fetched calendar, when it was fetched, and its upstream validators
+type cacheEntry struct {
+	data         []byte
+	fetchedAt    time.Time
+	etag         string
+	lastModified string
+	// lastUsed is when a request last looked the entry up, for eviction
+	lastUsed time.Time
+}
+
+// calendarCache is an in-memory cache of fetched calendars keyed by URL
+// Entries are kept past their TTL so they can be revalidated with conditional requests or
+// served stale, until they go unused for cacheIdleTimeout or are evicted to stay within maxCacheEntries
+type calendarCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+// calCache is the process-wide calendar cache, configured in main
+var calCache = newCalendarCache(0)
+
+// newCalendarCache creates a cache whose entries are fresh for ttl
+// A ttl of zero means every request revalidates with the upstream
+func newCalendarCache(ttl time.Duration) *calendarCache {
+	return &calendarCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// lookup returns the cached entry for url and whether it is still within the TTL
+func (c *calendarCache) lookup(url string) (cacheEntry, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[url]
+	if !ok {
+		return cacheEntry{}, false, false
+	}
+	now := time.Now()
+	if now.Sub(entry.lastUsed) > cacheIdleTimeout {
+		delete(c.entries, url)
+		return cacheEntry{}, false, false
+	}
+	entry.lastUsed = now
+	c.entries[url] = entry
+	fresh := c.ttl > 0 && now.Sub(entry.fetchedAt) <= c.ttl
+	return entry, fresh, true
+}
+
+// store saves a freshly fetched calendar for url
+// Idle entries are dropped first and, if the cache is still full, the least recently used one
+func (c *calendarCache) store(url string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	leastRecentURL := ""
+	var leastRecent time.Time
+	for cachedURL, cached := range c.entries {
+		if now.Sub(cached.lastUsed) > cacheIdleTimeout {
+			delete(c.entries, cachedURL)
+			continue
+		}
+		if leastRecentURL == "" || cached.lastUsed.Before(leastRecent) {
+			leastRecentURL, leastRecent = cachedURL, cached.lastUsed
+		}
+	}
+	if _, ok := c.entries[url]; !ok && len(c.entries) >= maxCacheEntries {
+		delete(c.entries, leastRecentURL)
+	}
+	entry.lastUsed = now
+	c.entries[url] = entry
+}
+
+// touch marks the cached entry for url as just fetched, e.g. after a 304 Not Modified
+func (c *calendarCache) touch(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[url]; ok {
+		entry.fetchedAt = time.Now()
+		c.entries[url] = entry
+	}
+}
+
+// cacheEntryStatus describes a cache entry for the health endpoint
+// The calendar URL is reduced to its host since share URLs often embed secrets
+type cacheEntryStatus struct {
+	Host         string    `json:"host"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	AgeSeconds   int       `json:"age_seconds"`
+	Fresh        bool      `json:"fresh"`
+	Bytes        int       `json:"bytes"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+}
+
+// cacheStatus describes the cache for the health endpoint
+type cacheStatus struct {
+	TTL     string             `json:"ttl"`
+	Entries []cacheEntryStatus `json:"entries"`
+}
+
+// status returns a snapshot of the cache state
+func (c *calendarCache) status() cacheStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := cacheStatus{TTL: c.ttl.String(), Entries: []cacheEntryStatus{}}
+	for calendarURL, entry := range c.entries {
+		host := calendarURL
+		if u, err := url.Parse(calendarURL); err == nil {
+			host = u.Host
+		}
+		age := time.Since(entry.fetchedAt)
+		status.Entries = append(status.Entries, cacheEntryStatus{
+			Host:         host,
+			FetchedAt:    entry.fetchedAt,
+			AgeSeconds:   int(age.Seconds()),
+			Fresh:        c.ttl > 0 && age <= c.ttl,
+			Bytes:        len(entry.data),
+			ETag:         entry.etag,
+			LastModified: entry.lastModified,
+		})
+	}
+	sort.Slice(status.Entries, func(i, j int) bool {
+		return status.Entries[i].Host < status.Entries[j].Host
+	})
+	return status
+}
+
+// getCacheTTL returns the cache TTL from the CACHE_TTL environment variable
+// Returns zero (caching disabled) if CACHE_TTL is not set
+func getCacheTTL() (time.Duration, error) {
+	ttlStr := getEnv("CACHE_TTL", "")
+	if ttlStr == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid CACHE_TTL: %s (expected a duration like 5m)", ttlStr)
+	}
+	return ttl, nil
+}
+
+// Where a loaded calendar came from, for request logs
+const (
+	// sourceCache is a fresh cache entry
+	sourceCache = "cache"
+	// sourceRevalidated is a cache entry the upstream confirmed with a 304
+	sourceRevalidated = "revalidated"
+	// sourceUpstream is a full fetch from the upstream
+	sourceUpstream = "upstream"
+	// sourceUpload is a calendar posted in the request body
+	sourceUpload = "upload"
+	// sourceStale is a cache entry, possibly expired, served because the upstream couldn't be fetched
+	sourceStale = "stale"
+)
+
+// serveStale enables answering from the last cached calendar when the upstream fails, configured in main
+var serveStale = true
+
+// setStaleHeader flags a response built from a stale cached calendar
+// X-Served-Stale is kept alongside X-Cache for clients that already check it
+func setStaleHeader(w http.ResponseWriter, source string) {
+	if source == sourceStale {
+		w.Header().Set("X-Cache", "stale")
+		w.Header().Set("X-Served-Stale", "true")
+	}
+}
+
+// fetchGroup collapses concurrent upstream fetches of the same calendar into one request
+var fetchGroup singleflight.Group
+
+// loadedCalendar is the shared result of a deduplicated upstream fetch
+type loadedCalendar struct {
+	data   []byte
+	source string
+}
+
+// loadCalendar returns the calendar at calendarURL, serving it from the cache when possible
+// Expired entries are revalidated with If-None-Match/If-Modified-Since and reused on a 304
+// bypassCache forces an unconditional fetch (the result still refreshes the cache)
+// Concurrent requests that need the upstream for the same URL share a single fetch
+// The returned source says whether the data came from the cache or the upstream
+func loadCalendar(calendarURL string, bypassCache bool) ([]byte, string, error) {
+	if !bypassCache {
+		if entry, fresh, ok := calCache.lookup(calendarURL); ok && fresh {
+			return entry.data, sourceCache, nil
+		}
+	}
+
+	// Bypassing requests get their own flight so they never reuse a conditional fetch
+	key := calendarURL
+	if bypassCache {
+		key = "nocache " + calendarURL
+	}
+	loaded, err, _ := fetchGroup.Do(key, func() (interface{}, error) {
+		return fetchAndCache(calendarURL, bypassCache)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	calendar := loaded.(loadedCalendar)
+	return calendar.data, calendar.source, nil
+}
+
+// fetchAndCache fetches calendarURL from the upstream, conditionally if it is cached, and refreshes the cache
+// When the fetch fails, including while the upstream's circuit is open, a cached copy is served
+// instead if serveStale allows it; forced fetches (bypassCache) never fall back
+func fetchAndCache(calendarURL string, bypassCache bool) (loadedCalendar, error) {
+	var validators cacheEntry
+	if !bypassCache {
+		if entry, _, ok := calCache.lookup(calendarURL); ok {
+			validators = entry
+		}
+	}
+
+	result, err := fetchCalendar(calendarURL, validators.etag, validators.lastModified)
+	if err != nil {
+		if entry, _, ok := calCache.lookup(calendarURL); ok && serveStale && !bypassCache && !isBlockedAddressError(err) {
+			log.Printf("Warning: serving stale calendar fetched at %s: %v", entry.fetchedAt.Format(time.RFC3339), err)
+			staleServedTotal.Inc()
+			return loadedCalendar{data: entry.data, source: sourceStale}, nil
+		}
+		return loadedCalendar{}, err
+	}
+
+	if result.notModified {
+		calCache.touch(calendarURL)
+		return loadedCalendar{data: validators.data, source: sourceRevalidated}, nil
+	}
+
+	calCache.store(calendarURL, cacheEntry{
+		data:         result.data,
+		fetchedAt:    time.Now(),
+		etag:         result.etag,
+		lastModified: result.lastModified,
+	})
+	return loadedCalendar{data: result.data, source: sourceUpstream}, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCalendarCacheEvicts(t *testing.T) {
+	cache := newCalendarCache(time.Minute)
+	cache.entries["http://example.com/idle"] = cacheEntry{
+		data:      []byte("BEGIN:VCALENDAR"),
+		fetchedAt: time.Now().Add(-2 * cacheIdleTimeout),
+		lastUsed:  time.Now().Add(-2 * cacheIdleTimeout),
+	}
+	if _, _, ok := cache.lookup("http://example.com/idle"); ok {
+		t.Error("lookup returned an entry idle for longer than cacheIdleTimeout")
+	}
+
+	for i := 0; i < maxCacheEntries; i++ {
+		cache.store(fmt.Sprintf("http://example.com/%d", i), cacheEntry{fetchedAt: time.Now()})
+	}
+	// Stores in a tight loop can share a timestamp, so set the use times explicitly
+	cache.entries["http://example.com/0"] = cacheEntry{lastUsed: time.Now().Add(time.Second)}
+	cache.entries["http://example.com/1"] = cacheEntry{lastUsed: time.Now().Add(-time.Second)}
+	cache.store("http://example.com/new", cacheEntry{fetchedAt: time.Now()})
+
+	if len(cache.entries) != maxCacheEntries {
+		t.Errorf("cache holds %d entries, want %d", len(cache.entries), maxCacheEntries)
+	}
+	if _, ok := cache.entries["http://example.com/1"]; ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	if _, ok := cache.entries["http://example.com/0"]; !ok {
+		t.Error("recently used entry was evicted")
+	}
+}
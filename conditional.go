@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// filterETag derives a validator for a /filter response from everything that determines it:
+// the upstream calendar, the query, the reloadable presets and profiles, the resolved time
+// ranges (a ranges_url definition can change behind the same query), the current date in the
+// filter timezone (relative windows like from=0d move with it) and whether the response is gzipped
+// Since the upstream data is hashed rather than its validators, calendars served without
+// ETag/Last-Modified work too
+func filterETag(r *http.Request, icsData []byte, criteria FilterOptions) string {
+	loc := criteria.Location
+	hash := sha256.New()
+	hash.Write(icsData)
+	fmt.Fprintf(hash, "\x00%s\x00%s\x00%t\x00", r.URL.RawQuery, time.Now().In(loc).Format("2006-01-02"), acceptsGzip(r))
+	json.NewEncoder(hash).Encode(getPresets())
+	json.NewEncoder(hash).Encode(getConfig())
+	json.NewEncoder(hash).Encode(criteria.TimeRanges)
+	return fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+}
+
+// filterLastModified returns the Last-Modified for a /filter response: the latest of the upstream
+// Last-Modified, the last config load and the start of today in the filter timezone, since any of
+// them can change the output. It is empty when the upstream sent no Last-Modified
+func filterLastModified(upstream string, loc *time.Location) string {
+	modified, err := http.ParseTime(upstream)
+	if err != nil {
+		return ""
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	for _, t := range []time.Time{getConfigLoadedAt(), today} {
+		if t.After(modified) {
+			modified = t
+		}
+	}
+	return modified.UTC().Format(http.TimeFormat)
+}
+
+// etagMatches reports whether an If-None-Match header value lists etag, or is *
+// Weak comparison is used, as RFC 9110 requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNotModified sets the ETag and Last-Modified validators on a response and reports
+// whether the client's copy is current, in which case it writes a 304 and nothing else is needed
+// If-Modified-Since is only consulted when the request has no If-None-Match, and only when the
+// upstream supplied a Last-Modified
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag, lastModified string) bool {
+	w.Header().Set("ETag", etag)
+	if lastModified != "" {
+		w.Header().Set("Last-Modified", lastModified)
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if !etagMatches(ifNoneMatch, etag) {
+			return false
+		}
+	} else {
+		modified, err := http.ParseTime(lastModified)
+		if err != nil {
+			return false
+		}
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || modified.After(since) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
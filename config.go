@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a named set of filter parameters defined in the config file
+// Fields mirror the /filter query parameters; values given on a request take precedence
+type Profile struct {
+	Ranges           []string `json:"ranges,omitempty" yaml:"ranges"`
+	RangesMinutes    []string `json:"ranges_minutes,omitempty" yaml:"ranges_minutes"`
+	RangesURL        string   `json:"ranges_url,omitempty" yaml:"ranges_url"`
+	Days             []string `json:"days,omitempty" yaml:"days"`
+	Match            string   `json:"match,omitempty" yaml:"match"`
+	Tolerance        string   `json:"tolerance,omitempty" yaml:"tolerance"`
+	Combine          string   `json:"combine,omitempty" yaml:"combine"`
+	Timezone         string   `json:"timezone,omitempty" yaml:"timezone"`
+	Invert           bool     `json:"invert,omitempty" yaml:"invert"`
+	Fold             bool     `json:"fold,omitempty" yaml:"fold"`
+	TitleContains    []string `json:"title_contains,omitempty" yaml:"title_contains"`
+	TitleRegex       []string `json:"title_regex,omitempty" yaml:"title_regex"`
+	LocationContains []string `json:"location_contains,omitempty" yaml:"location_contains"`
+	DescContains     []string `json:"desc_contains,omitempty" yaml:"desc_contains"`
+	Categories       []string `json:"categories,omitempty" yaml:"categories"`
+	Classes          []string `json:"classes,omitempty" yaml:"classes"`
+	EventTypes       []string `json:"event_types,omitempty" yaml:"event_types"`
+	UIDs             []string `json:"uids,omitempty" yaml:"uids"`
+	Has              []string `json:"has,omitempty" yaml:"has"`
+	Missing          []string `json:"missing,omitempty" yaml:"missing"`
+	AllDay           string   `json:"all_day,omitempty" yaml:"all_day"`
+	Transparency     string   `json:"transparency,omitempty" yaml:"transparency"`
+	Recurrence       string   `json:"recurrence,omitempty" yaml:"recurrence"`
+	MinAttendees     *int     `json:"min_attendees,omitempty" yaml:"min_attendees"`
+	MaxAttendees     *int     `json:"max_attendees,omitempty" yaml:"max_attendees"`
+	MinDuration      string   `json:"min_duration,omitempty" yaml:"min_duration"`
+	MaxDuration      string   `json:"max_duration,omitempty" yaml:"max_duration"`
+	EffectiveFrom    string   `json:"effective_from,omitempty" yaml:"effective_from"`
+	EffectiveTo      string   `json:"effective_to,omitempty" yaml:"effective_to"`
+	From             string   `json:"from,omitempty" yaml:"from"`
+	To               string   `json:"to,omitempty" yaml:"to"`
+	Expand           bool     `json:"expand,omitempty" yaml:"expand"`
+	Window           string   `json:"window,omitempty" yaml:"window"`
+}
+
+// Config is the contents of the file named by CONFIG_FILE
+// Presets add to or override the built-in and PRESETS ranges
+type Config struct {
+	Presets  map[string]string  `json:"presets,omitempty" yaml:"presets"`
+	Profiles map[string]Profile `json:"profiles,omitempty" yaml:"profiles"`
+}
+
+var (
+	configMu      sync.RWMutex
+	currentConfig Config
+	// configLoadedAt is when currentConfig (and the presets) were last loaded
+	configLoadedAt time.Time
+)
+
+// getConfig returns the currently loaded config
+func getConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return currentConfig
+}
+
+// getConfigLoadedAt returns when the config was last loaded
+func getConfigLoadedAt() time.Time {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return configLoadedAt
+}
+
+// loadConfigFile reads and validates a YAML or JSON config file
+func loadConfigFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// YAML is a superset of JSON, so one decoder handles both formats
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// reloadConfig loads presets and the CONFIG_FILE (if set) and makes them current
+// On error the previous configuration is left in place
+func reloadConfig() error {
+	presets, err := loadPresets()
+	if err != nil {
+		return err
+	}
+
+	var config Config
+	if path := getEnv("CONFIG_FILE", ""); path != "" {
+		config, err = loadConfigFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, value := range config.Presets {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || strings.Contains(name, "-") {
+			return fmt.Errorf("invalid preset name in config file: %q", name)
+		}
+		if _, err := parseTimeRange(value); err != nil {
+			return fmt.Errorf("invalid preset %s in config file: %w", name, err)
+		}
+		presets[name] = value
+	}
+
+	// Profiles may refer to presets, so validate them against the new presets before swapping anything in
+	if err := validateProfiles(config, presets); err != nil {
+		return err
+	}
+
+	// Publish the presets and profiles together so no request sees one without the other
+	presetsMu.Lock()
+	configMu.Lock()
+	rangePresets = presets
+	currentConfig = config
+	configLoadedAt = time.Now()
+	configMu.Unlock()
+	presetsMu.Unlock()
+
+	log.Printf("Loaded %d presets and %d profiles", len(presets), len(config.Profiles))
+	return nil
+}
+
+// validateProfiles checks that every profile parses as a filter request
+// Preset names in a profile's ranges are resolved against presets, the candidate presets being
+// loaded alongside it, rather than the ones in effect
+// A profile's ranges_url is only checked for its syntax and scheme: fetching it here would
+// make startup and every reload depend on the ranges host being reachable
+func validateProfiles(config Config, presets map[string]string) error {
+	for name, profile := range config.Profiles {
+		values := url.Values{}
+		mergeProfileIntoQuery(values, profile)
+		if ranges := values.Get("ranges"); ranges != "" {
+			values.Set("ranges", resolvePresets(ranges, presets))
+		}
+		if rangesURL := values.Get("ranges_url"); rangesURL != "" {
+			if _, err := parseRangesURL(rangesURL); err != nil {
+				return fmt.Errorf("invalid profile %s: %w", name, err)
+			}
+			values.Del("ranges_url")
+		}
+		req, err := http.NewRequest(http.MethodGet, "/?"+values.Encode(), nil)
+		if err != nil {
+			return fmt.Errorf("invalid profile %s: %w", name, err)
+		}
+		if _, err := parseFilterOptions(req); err != nil {
+			return fmt.Errorf("invalid profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// watchConfigReload reloads the configuration whenever the process receives SIGHUP
+func watchConfigReload() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Printf("Received SIGHUP, reloading configuration")
+			if err := reloadConfig(); err != nil {
+				log.Printf("Warning: failed to reload configuration, keeping previous: %v", err)
+			}
+		}
+	}()
+}
+
+// profileNames returns the sorted names of the configured profiles
+func profileNames(config Config) []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mergeProfileIntoQuery fills query parameters from a profile, leaving parameters already present untouched
+func mergeProfileIntoQuery(query url.Values, profile Profile) {
+	setIfAbsent := func(key string, values ...string) {
+		if _, ok := query[key]; ok || len(values) == 0 {
+			return
+		}
+		query[key] = values
+	}
+
+	if len(profile.Ranges) > 0 && query.Get("start") == "" {
+		setIfAbsent("ranges", strings.Join(profile.Ranges, ","))
+	}
+	if len(profile.RangesMinutes) > 0 {
+		setIfAbsent("ranges_minutes", strings.Join(profile.RangesMinutes, ","))
+	}
+	if profile.RangesURL != "" {
+		setIfAbsent("ranges_url", profile.RangesURL)
+	}
+	if len(profile.Days) > 0 {
+		setIfAbsent("days", strings.Join(profile.Days, ","))
+	}
+	if profile.Match != "" {
+		setIfAbsent("match", profile.Match)
+	}
+	if profile.Tolerance != "" {
+		setIfAbsent("tolerance", profile.Tolerance)
+	}
+	if profile.Combine != "" {
+		setIfAbsent("combine", profile.Combine)
+	}
+	if profile.Timezone != "" {
+		setIfAbsent("tz", profile.Timezone)
+	}
+	if profile.Invert {
+		setIfAbsent("invert", "true")
+	}
+	if profile.Fold {
+		setIfAbsent("fold", "true")
+	}
+	setIfAbsent("title_contains", profile.TitleContains...)
+	setIfAbsent("title_regex", profile.TitleRegex...)
+	setIfAbsent("location_contains", profile.LocationContains...)
+	setIfAbsent("desc_contains", profile.DescContains...)
+	setIfAbsent("categories", profile.Categories...)
+	setIfAbsent("class", profile.Classes...)
+	setIfAbsent("eventtype", profile.EventTypes...)
+	setIfAbsent("uid", profile.UIDs...)
+	setIfAbsent("has", profile.Has...)
+	setIfAbsent("missing", profile.Missing...)
+	if profile.AllDay != "" {
+		setIfAbsent("all_day", profile.AllDay)
+	}
+	if profile.Transparency != "" {
+		setIfAbsent("transparency", profile.Transparency)
+	}
+	if profile.Recurrence != "" {
+		setIfAbsent("recurrence", profile.Recurrence)
+	}
+	if profile.MinAttendees != nil {
+		setIfAbsent("min_attendees", strconv.Itoa(*profile.MinAttendees))
+	}
+	if profile.MaxAttendees != nil {
+		setIfAbsent("max_attendees", strconv.Itoa(*profile.MaxAttendees))
+	}
+	if profile.MinDuration != "" {
+		setIfAbsent("min_duration", profile.MinDuration)
+	}
+	if profile.MaxDuration != "" {
+		setIfAbsent("max_duration", profile.MaxDuration)
+	}
+	if profile.EffectiveFrom != "" {
+		setIfAbsent("effective_from", profile.EffectiveFrom)
+	}
+	if profile.EffectiveTo != "" {
+		setIfAbsent("effective_to", profile.EffectiveTo)
+	}
+	if profile.From != "" {
+		setIfAbsent("from", profile.From)
+	}
+	if profile.To != "" {
+		setIfAbsent("to", profile.To)
+	}
+	if profile.Expand {
+		setIfAbsent("expand", "true")
+	}
+	if profile.Window != "" {
+		setIfAbsent("window", profile.Window)
+	}
+}
+
+// applyProfile returns a copy of the request with the named profile's parameters merged into its query
+// Requests without a profile parameter are returned unchanged
+func applyProfile(r *http.Request) (*http.Request, error) {
+	name := r.URL.Query().Get("profile")
+	if name == "" {
+		return r, nil
+	}
+
+	config := getConfig()
+	profile, ok := config.Profiles[name]
+	if !ok {
+		names := profileNames(config)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown profile: %s (no profiles configured)", name)
+		}
+		return nil, fmt.Errorf("unknown profile: %s (valid names: %s)", name, strings.Join(names, ", "))
+	}
+
+	query := r.URL.Query()
+	mergeProfileIntoQuery(query, profile)
+
+	profiled := r.Clone(r.Context())
+	profiled.URL.RawQuery = query.Encode()
+	return profiled, nil
+}
+
+// profileListing is a single entry in the /profiles response
+type profileListing struct {
+	Name       string  `json:"name"`
+	Definition Profile `json:"definition"`
+}
+
+// handleProfiles lists the configured filter profiles, sorted by name
+func handleProfiles(w http.ResponseWriter, r *http.Request) {
+	config := getConfig()
+	listings := make([]profileListing, 0, len(config.Profiles))
+	for _, name := range profileNames(config) {
+		listings = append(listings, profileListing{Name: name, Definition: config.Profiles[name]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(listings)
+}
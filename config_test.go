@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfigValidatesProfilesAgainstNewPresets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("CONFIG_FILE", path)
+	t.Cleanup(func() {
+		os.Unsetenv("CONFIG_FILE")
+		reloadConfig()
+	})
+	write := func(config string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A profile may use a preset defined in the same file
+	write("presets:\n  focus: \"09:00-11:00\"\nprofiles:\n  work:\n    ranges: [focus, lunch]\n")
+	if err := reloadConfig(); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if getPresets()["focus"] != "09:00-11:00" || len(getConfig().Profiles["work"].Ranges) == 0 {
+		t.Fatal("valid presets and profiles were not published")
+	}
+
+	// A rejected reload publishes neither its presets nor its profiles
+	write("presets:\n  deep: \"13:00-15:00\"\nprofiles:\n  broken:\n    ranges: [deep, nosuchpreset]\n")
+	if err := reloadConfig(); err == nil || !strings.Contains(err.Error(), "invalid profile broken") {
+		t.Fatalf("reloadConfig: err = %v, want an invalid profile error", err)
+	}
+	if _, ok := getPresets()["deep"]; ok {
+		t.Error("presets from the rejected reload were published")
+	}
+	if _, ok := getConfig().Profiles["broken"]; ok {
+		t.Error("profiles from the rejected reload were published")
+	}
+	if getPresets()["focus"] != "09:00-11:00" {
+		t.Error("previous presets were not kept")
+	}
+}
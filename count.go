@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CountResponse is the body returned by /count
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+// handleCount returns the number of events left after filtering, without the calendar itself
+// It accepts the same parameters as /filter; with no filters it counts every event
+func handleCount(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	criteria, err := parseFilterOptions(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	nocache, err := parseBoolParam(r, "nocache", false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	calendarURL, err := requestCalendarURL(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid calendar: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	icsData, source, err := loadCalendar(calendarURL, nocache)
+	if isBlockedAddressError(err) {
+		http.Error(w, fmt.Sprintf("Invalid calendar: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch calendar: %v", err), http.StatusBadGateway)
+		return
+	}
+	setStaleHeader(w, source)
+
+	result, err := filterCalendar(icsData, criteria)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to filter calendar: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logFilterCounts(r, criteria, result.OriginalCount, len(result.Kept), time.Since(start), source)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(CountResponse{Count: len(result.Kept)})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DebugConfig is the body returned by /debug/config
+// Secrets are never included: calendar URLs are reduced to their host and
+// credentials are only reported as configured or not
+type DebugConfig struct {
+	Calendars       map[string]string  `json:"calendars"`
+	Port            string             `json:"port"`
+	CacheTTL        string             `json:"cache_ttl"`
+	FetchTimeout    string             `json:"fetch_timeout"`
+	MaxCalendarSize int64              `json:"max_calendar_bytes"`
+	BreakerLimit    int                `json:"circuit_breaker_threshold"`
+	BreakerCooldown string             `json:"circuit_breaker_cooldown"`
+	DefaultTimezone string             `json:"default_timezone"`
+	DefaultRanges   string             `json:"default_ranges,omitempty"`
+	AllowedHosts    []string           `json:"allowed_hosts,omitempty"`
+	CORSOrigins     []string           `json:"cors_origins,omitempty"`
+	CalendarAuth    bool               `json:"calendar_auth"`
+	TokenAuth       bool               `json:"token_auth"`
+	LogFormat       string             `json:"log_format"`
+	ConfigFile      string             `json:"config_file,omitempty"`
+	Profiles        map[string]Profile `json:"profiles"`
+}
+
+// redactCalendarURL reduces a calendar URL to its scheme and host
+// Feed paths and queries often embed a private access key, so neither is shown
+func redactCalendarURL(raw string) string {
+	parsed, err := url.Parse(normalizeCalendarURL(raw))
+	if err != nil || parsed.Host == "" {
+		return "[invalid URL]"
+	}
+	redacted := parsed.Scheme + "://" + parsed.Host
+	if (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" {
+		redacted += "/[redacted]"
+	}
+	return redacted
+}
+
+// handleDebugConfig returns the settings the service resolved at startup, for diagnosing
+// environment misconfiguration; port is the listening port chosen in main
+func handleDebugConfig(port string, fetchTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		calendars := map[string]string{}
+		if configured, err := getCalendarURLs(); err == nil {
+			for name, calendarURL := range configured {
+				calendars[name] = redactCalendarURL(calendarURL)
+			}
+		}
+
+		profiles := getConfig().Profiles
+		if profiles == nil {
+			profiles = map[string]Profile{}
+		}
+
+		logFormat := logFormatText
+		if jsonLogging {
+			logFormat = logFormatJSON
+		}
+
+		config := DebugConfig{
+			Calendars:       calendars,
+			Port:            port,
+			CacheTTL:        calCache.ttl.String(),
+			FetchTimeout:    fetchTimeout.String(),
+			MaxCalendarSize: maxCalendarBytes,
+			BreakerLimit:    fetchBreaker.threshold,
+			BreakerCooldown: fetchBreaker.cooldown.String(),
+			DefaultTimezone: defaultLocation.String(),
+			DefaultRanges:   getEnv("DEFAULT_RANGES", ""),
+			AllowedHosts:    allowedHosts,
+			CORSOrigins:     corsOrigins,
+			CalendarAuth:    calendarUsername != "",
+			TokenAuth:       authToken != "",
+			LogFormat:       logFormat,
+			ConfigFile:      getEnv("CONFIG_FILE", ""),
+			Profiles:        profiles,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(config)
+	}
+}
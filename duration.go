@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// componentPropertyDuration is the DURATION property, which the ics library doesn't define as a component property
+const componentPropertyDuration = ics.ComponentProperty(ics.PropertyDuration)
+
+// icalDurationPattern matches an RFC 5545 DURATION value such as PT1H30M, P1DT12H or P2W
+var icalDurationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W|(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?)$`)
+
+// icalDuration is a parsed DURATION value
+// Days are kept apart from the exact time part because they are nominal: a day
+// across a daylight saving change is still one calendar day, not 24 hours
+type icalDuration struct {
+	days     int
+	exact    time.Duration
+	negative bool
+}
+
+// parseICalDuration parses an RFC 5545 DURATION value
+func parseICalDuration(value string) (icalDuration, error) {
+	m := icalDurationPattern.FindStringSubmatch(value)
+	// The pattern lets every part be empty, so also require a date or time value,
+	// and a time value whenever the T designator is present
+	if m == nil || m[2]+m[3]+m[4]+m[5]+m[6] == "" || (strings.Contains(value, "T") && m[4]+m[5]+m[6] == "") {
+		return icalDuration{}, fmt.Errorf("invalid DURATION: %s", value)
+	}
+	number := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	return icalDuration{
+		days: number(m[2])*7 + number(m[3]),
+		exact: time.Duration(number(m[4]))*time.Hour +
+			time.Duration(number(m[5]))*time.Minute +
+			time.Duration(number(m[6]))*time.Second,
+		negative: m[1] == "-",
+	}, nil
+}
+
+// addTo returns start moved by the duration, adding days on the calendar in start's location
+func (d icalDuration) addTo(start time.Time) time.Time {
+	if d.negative {
+		return start.AddDate(0, 0, -d.days).Add(-d.exact)
+	}
+	return start.AddDate(0, 0, d.days).Add(d.exact)
+}
+
+// eventEndAt returns when an event ends, computing it from DTSTART plus DURATION
+// for events that have no DTEND
+func eventEndAt(event *ics.VEvent) (time.Time, error) {
+	end, err := event.GetEndAt()
+	if err == nil || event.GetProperty(ics.ComponentPropertyDtEnd) != nil {
+		return end, err
+	}
+	durationProp := event.GetProperty(componentPropertyDuration)
+	if durationProp == nil {
+		return end, err
+	}
+	duration, parseErr := parseICalDuration(durationProp.Value)
+	if parseErr != nil {
+		return time.Time{}, parseErr
+	}
+	start, startErr := event.GetStartAt()
+	if startErr != nil {
+		return time.Time{}, startErr
+	}
+	return duration.addTo(start), nil
+}
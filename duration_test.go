@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventEndAtFromDuration(t *testing.T) {
+	start := time.Date(2024, time.January, 4, 14, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		event string
+		want  time.Time
+	}{
+		{"hours and minutes", "UID:a\nDTSTART:20240104T140000Z\nDURATION:PT1H30M", start.Add(90 * time.Minute)},
+		{"days", "UID:b\nDTSTART:20240104T140000Z\nDURATION:P1D", start.AddDate(0, 0, 1)},
+		{"DTEND wins over DURATION", "UID:c\nDTSTART:20240104T140000Z\nDTEND:20240104T150000Z\nDURATION:PT2H", start.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := parseTestCalendar(t, tt.event).Events()[0]
+			got, err := eventEndAt(event)
+			if err != nil {
+				t.Fatalf("eventEndAt: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("eventEndAt = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMatchesDurationEvents(t *testing.T) {
+	cal := parseTestCalendar(t, "UID:review\nDTSTART:20240104T140000Z\nDURATION:PT1H30M")
+
+	filtered, stats := Filter(cal, testOptions(mustTimeRange(t, "14:00", "15:30")))
+
+	if len(filtered.Events()) != 0 || len(stats.Removed) != 1 {
+		t.Errorf("kept %d events, want the DURATION event matched and removed", len(filtered.Events()))
+	}
+	if stats.Unparseable != 0 {
+		t.Errorf("Unparseable = %d, want 0", stats.Unparseable)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Event types, named after Google Calendar's eventType values and compared in lower case
+const (
+	// eventTypeDefault is an ordinary event, and any event that carries no type
+	eventTypeDefault = "default"
+	// eventTypeOutOfOffice is an out-of-office block
+	eventTypeOutOfOffice = "outofoffice"
+	// eventTypeFocusTime is a focus time block
+	eventTypeFocusTime = "focustime"
+	// eventTypeWorkingLocation is a working location marker
+	eventTypeWorkingLocation = "workinglocation"
+)
+
+// eventTypeAliases maps shorthand eventtype values to event types
+var eventTypeAliases = map[string]string{
+	"ooo":   eventTypeOutOfOffice,
+	"oof":   eventTypeOutOfOffice,
+	"focus": eventTypeFocusTime,
+}
+
+// eventTypeProperties are the extension properties that carry an event's type, in the order checked;
+// values are Google eventType names such as outOfOffice or focusTime
+var eventTypeProperties = []string{"X-GOOGLE-EVENT-TYPE", "X-EVENT-TYPE"}
+
+// microsoftBusyStatus is the property Outlook and Exchange use to mark out-of-office (OOF) events
+const microsoftBusyStatus = "X-MICROSOFT-CDO-BUSYSTATUS"
+
+// normalizeEventType lower-cases an event type and drops separators, so "outOfOffice",
+// "out-of-office" and "OUT_OF_OFFICE" compare equal, then resolves aliases
+func normalizeEventType(value string) string {
+	normalized := strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(value)))
+	if alias, ok := eventTypeAliases[normalized]; ok {
+		return alias
+	}
+	return normalized
+}
+
+// parseEventTypes validates the values of the eventtype parameter, returning them normalized
+func parseEventTypes(values []string) ([]string, error) {
+	var types []string
+	for _, value := range splitList(values) {
+		eventType := normalizeEventType(value)
+		switch eventType {
+		case eventTypeDefault, eventTypeOutOfOffice, eventTypeFocusTime, eventTypeWorkingLocation:
+			types = append(types, eventType)
+		default:
+			return nil, fmt.Errorf("invalid eventtype: %s (expected ooo, focus, workinglocation or default)", value)
+		}
+	}
+	return types, nil
+}
+
+// eventType returns an event's type from the first of eventTypeProperties it has, or out of office
+// when Outlook marks it OOF. Feeds that carry none of these, as most do, make every event default
+func eventType(event *ics.VEvent) string {
+	for _, name := range eventTypeProperties {
+		if prop := event.GetProperty(ics.ComponentProperty(name)); prop != nil && strings.TrimSpace(prop.Value) != "" {
+			return normalizeEventType(prop.Value)
+		}
+	}
+	if prop := event.GetProperty(ics.ComponentProperty(microsoftBusyStatus)); prop != nil &&
+		strings.EqualFold(strings.TrimSpace(prop.Value), "OOF") {
+		return eventTypeOutOfOffice
+	}
+	return eventTypeDefault
+}
+
+// hasAnyEventType reports whether an event's type is one of the given normalized types
+func hasAnyEventType(event *ics.VEvent, types []string) bool {
+	eventType := eventType(event)
+	for _, candidate := range types {
+		if candidate == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// defaultKeepUnparseable is the keep_unparseable default: events whose times can't be
+// determined are passed through rather than silently lost
+const defaultKeepUnparseable = true
+
+// FilterOptions holds the rules used to decide which events match
+// An event matches if it satisfies any of the configured rules
+type FilterOptions struct {
+	TimeRanges []TimeRange
+	// EffectiveFrom and EffectiveTo limit TimeRanges to events starting in [from, to); zero means unbounded
+	EffectiveFrom time.Time
+	EffectiveTo   time.Time
+	// WindowFrom and WindowTo drop events starting outside [from, to) before any other rule; zero means unbounded
+	WindowFrom time.Time
+	WindowTo   time.Time
+	DateRanges []DateRange
+	Location   *time.Location
+	Match      string
+	// Tolerance is how far exact and start_only matches may be from a range boundary
+	Tolerance time.Duration
+	// Combine is combineOr (any active filter matches) or combineAnd (all active filters match)
+	Combine       string
+	Invert        bool
+	TitleContains []string
+	TitleRegex    []*regexp.Regexp
+	AllDay        string
+	// Transparency keeps only opaque or only transparent events; empty keeps both
+	Transparency string
+	// Recurrence keeps only recurring series or only one-off events; empty keeps both
+	Recurrence string
+
+	LocationContains []string
+	// DescContains match events whose DESCRIPTION contains any keyword, ignoring case and line breaks
+	DescContains []string
+	// Categories match events tagged with any of the values in CATEGORIES, case-insensitively
+	Categories []string
+	// Classes match events whose CLASS is one of the values, case-insensitively; a missing CLASS is PUBLIC
+	Classes []string
+	// EventTypes match events whose type (see eventType) is one of the normalized values
+	EventTypes []string
+	// UIDs match events whose UID is exactly one of the values
+	UIDs []string
+	// Has and Missing match events with, or without, any of the named properties (upper case)
+	Has     []string
+	Missing []string
+	// Fold strips diacritics before comparing keyword filters, so "cafe" matches "Café";
+	// the keywords in TitleContains, LocationContains and DescContains are stored folded
+	Fold bool
+	// MinAttendees and MaxAttendees match events with fewer or more ATTENDEE properties; nil means no limit
+	MinAttendees *int
+	MaxAttendees *int
+	// MinDuration and MaxDuration match events shorter or longer than the limits; nil means no limit
+	MinDuration *time.Duration
+	MaxDuration *time.Duration
+	// KeepUnparseable passes events whose start/end can't be determined through unfiltered
+	// instead of dropping them; it defaults to defaultKeepUnparseable
+	KeepUnparseable bool
+	// Dedupe drops events repeating the UID of an earlier event, before any other filter runs
+	// An overridden occurrence (RECURRENCE-ID) is only a duplicate of another override of the same occurrence
+	Dedupe bool
+	// Expand evaluates each occurrence of recurring events between ExpandFrom and ExpandTo
+	Expand     bool
+	ExpandFrom time.Time
+	ExpandTo   time.Time
+}
+
+// parseFilterOptions parses every filter input for a request into FilterOptions
+// This is the single place filter parameters are read, so new filters only need adding here
+// A JSON body (POST) is used first, falling back to query parameters for anything it doesn't set
+// A profile parameter fills in any query parameters the request doesn't set itself
+func parseFilterOptions(r *http.Request) (FilterOptions, error) {
+	r, err := applyProfile(r)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	var filterRanges []TimeRange
+	var dateRanges []DateRange
+	var titleContains []string
+	var titlePatterns []string
+	var allDayParam string
+	var transparencyParam string
+	var recurrenceParam string
+	var locationContains []string
+	var descContains []string
+	var categories []string
+	var classes []string
+	var eventTypeParams []string
+	var requestedUIDs []string
+	var hasParam, missingParam []string
+	var minAttendees, maxAttendees *int
+	var minDurationParam, maxDurationParam string
+	var effectiveFromParam, effectiveToParam string
+	var windowFromParam, windowToParam string
+	// Timezone resolution: the JSON timezone, then the tz parameter, then DEFAULT_TZ, then local time
+	filterLoc := defaultLocation
+	jsonTimezone := false
+	invert := false
+	fold := false
+	// jsonRanges records whether the JSON body set time_ranges or date_ranges itself, even to an empty list
+	jsonRanges := false
+
+	// Try to parse from JSON body first (uploaded calendars take the body instead)
+	if r.Method == http.MethodPost && !isCalendarUpload(r) {
+		var req FilterRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err == nil {
+			jsonRanges = req.TimeRanges != nil || req.WeekdayRanges != nil || req.DateRanges != nil
+			filterRanges = req.TimeRanges
+			weekdayRanges, err := expandWeekdayRanges(req.WeekdayRanges)
+			if err != nil {
+				return FilterOptions{}, err
+			}
+			filterRanges = append(filterRanges, weekdayRanges...)
+			invert = req.Invert
+			fold = req.Fold
+			titleContains = req.TitleContains
+			titlePatterns = req.TitleRegex
+			allDayParam = req.AllDay
+			transparencyParam = req.Transparency
+			recurrenceParam = req.Recurrence
+			locationContains = req.LocationContains
+			descContains = req.DescContains
+			categories = req.Categories
+			classes = req.Classes
+			eventTypeParams = req.EventTypes
+			requestedUIDs = req.UIDs
+			hasParam = req.Has
+			missingParam = req.Missing
+			minAttendees = req.MinAttendees
+			maxAttendees = req.MaxAttendees
+			minDurationParam = req.MinDuration
+			maxDurationParam = req.MaxDuration
+			effectiveFromParam = req.EffectiveFrom
+			effectiveToParam = req.EffectiveTo
+			windowFromParam = req.From
+			windowToParam = req.To
+			if req.Timezone != "" {
+				loc, err := loadTimezone(req.Timezone)
+				if err != nil {
+					return FilterOptions{}, fmt.Errorf("invalid timezone: %s (%w)", req.Timezone, err)
+				}
+				filterLoc = loc
+				jsonTimezone = true
+			} else if tzParam := r.URL.Query().Get("tz"); tzParam != "" {
+				loc, err := loadTimezone(tzParam)
+				if err != nil {
+					return FilterOptions{}, fmt.Errorf("invalid timezone: %s (%w)", tzParam, err)
+				}
+				filterLoc = loc
+			}
+			for _, dr := range req.DateRanges {
+				dateRange, err := parseDateRange(dr.Start, dr.End, filterLoc)
+				if err != nil {
+					return FilterOptions{}, err
+				}
+				dateRanges = append(dateRanges, dateRange)
+			}
+		}
+	}
+
+	if err := validateRangeDays(filterRanges); err != nil {
+		return FilterOptions{}, err
+	}
+	if err := validateRangeOrders(filterRanges); err != nil {
+		return FilterOptions{}, err
+	}
+	if err := validateRangeMatches(filterRanges); err != nil {
+		return FilterOptions{}, err
+	}
+
+	// If no JSON body, parsing failed or the body didn't mention ranges, try query parameters
+	// An explicit empty list in the body means no range filtering
+	if !jsonRanges {
+		var queryLoc *time.Location
+		var err error
+		filterRanges, queryLoc, err = parseTimeRangesFromQuery(r)
+		if err != nil {
+			return FilterOptions{}, err
+		}
+		if !jsonTimezone {
+			filterLoc = queryLoc
+		}
+		dateRanges, err = parseDateRangesFromQuery(r, filterLoc)
+		if err != nil {
+			return FilterOptions{}, err
+		}
+	}
+
+	// A shared definition from ranges_url adds to the request's own ranges
+	if rangesURL := r.URL.Query().Get("ranges_url"); rangesURL != "" {
+		sharedRanges, err := rangesFromURL(rangesURL)
+		if err != nil {
+			return FilterOptions{}, err
+		}
+		filterRanges = append(filterRanges, sharedRanges...)
+	}
+
+	// DEFAULT_RANGES stands in for a request without ranges of its own, unless nofilter is set
+	if len(filterRanges) == 0 && !jsonRanges && len(defaultRanges) > 0 {
+		noFilter, err := parseBoolParam(r, "nofilter", false)
+		if err != nil {
+			return FilterOptions{}, err
+		}
+		if !noFilter {
+			filterRanges = defaultRanges
+		}
+	}
+
+	if effectiveFromParam == "" {
+		effectiveFromParam = r.URL.Query().Get("effective_from")
+	}
+	if effectiveToParam == "" {
+		effectiveToParam = r.URL.Query().Get("effective_to")
+	}
+	effectiveFrom, effectiveTo, err := parseEffectiveWindow(effectiveFromParam, effectiveToParam, filterLoc)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	// Title keywords from the query are combined with any from the JSON body
+	titleContains = append(titleContains, r.URL.Query()["title_contains"]...)
+	titlePatterns = append(titlePatterns, r.URL.Query()["title_regex"]...)
+	locationContains = append(locationContains, r.URL.Query()["location_contains"]...)
+	descContains = append(descContains, r.URL.Query()["desc_contains"]...)
+	categories = splitList(append(categories, r.URL.Query()["categories"]...))
+	classes = splitList(append(classes, r.URL.Query()["class"]...))
+	// UIDs may contain commas, so each uid parameter is a single value
+	var uids []string
+	for _, uid := range append(requestedUIDs, r.URL.Query()["uid"]...) {
+		if uid = strings.TrimSpace(uid); uid != "" {
+			uids = append(uids, uid)
+		}
+	}
+
+	eventTypes, err := parseEventTypes(append(eventTypeParams, r.URL.Query()["eventtype"]...))
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	has, err := parsePropertyNames("has", append(hasParam, r.URL.Query()["has"]...))
+	if err != nil {
+		return FilterOptions{}, err
+	}
+	missing, err := parsePropertyNames("missing", append(missingParam, r.URL.Query()["missing"]...))
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	// Compile title patterns once per request, before fetching the calendar
+	titleRegex, err := compileRegexList(titlePatterns)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	mode, err := parseMatchMode(r)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	toleranceLimit, err := parseDurationLimit("tolerance", r.URL.Query().Get("tolerance"))
+	if err != nil {
+		return FilterOptions{}, err
+	}
+	var tolerance time.Duration
+	if toleranceLimit != nil {
+		tolerance = *toleranceLimit
+	}
+
+	combine, err := parseCombineMode(r)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	if allDayParam == "" {
+		allDayParam = r.URL.Query().Get("all_day")
+	}
+	allDay, err := parseAllDayMode(allDayParam)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	if transparencyParam == "" {
+		transparencyParam = r.URL.Query().Get("transparency")
+	}
+	transparency, err := parseTransparencyMode(transparencyParam)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	if recurrenceParam == "" {
+		recurrenceParam = r.URL.Query().Get("recurrence")
+	}
+	recurrence, err := parseRecurrenceMode(recurrenceParam)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	if !fold {
+		if fold, err = parseBoolParam(r, "fold", false); err != nil {
+			return FilterOptions{}, err
+		}
+	}
+	if fold {
+		for _, keywords := range [][]string{titleContains, locationContains, descContains} {
+			for i, keyword := range keywords {
+				keywords[i] = foldDiacritics(keyword)
+			}
+		}
+	}
+
+	if !invert {
+		invert, err = parseInvert(r)
+		if err != nil {
+			return FilterOptions{}, err
+		}
+	}
+
+	keepUnparseable, err := parseBoolParam(r, "keep_unparseable", defaultKeepUnparseable)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	dedupe, err := parseBoolParam(r, "dedupe", false)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+
+	if minAttendees == nil {
+		if minAttendees, err = parseCountParam(r, "min_attendees"); err != nil {
+			return FilterOptions{}, err
+		}
+	}
+	if maxAttendees == nil {
+		if maxAttendees, err = parseCountParam(r, "max_attendees"); err != nil {
+			return FilterOptions{}, err
+		}
+	}
+	if minDurationParam == "" {
+		minDurationParam = r.URL.Query().Get("min_duration")
+	}
+	minDuration, err := parseDurationLimit("min_duration", minDurationParam)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+	if maxDurationParam == "" {
+		maxDurationParam = r.URL.Query().Get("max_duration")
+	}
+	maxDuration, err := parseDurationLimit("max_duration", maxDurationParam)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+	if minDuration != nil && maxDuration != nil && *minDuration > *maxDuration {
+		return FilterOptions{}, fmt.Errorf("min_duration (%s) must not exceed max_duration (%s)", minDuration, maxDuration)
+	}
+
+	expand, err := parseBoolParam(r, "expand", false)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+	expandWindow := defaultExpandWindow
+	if windowParam := r.URL.Query().Get("window"); windowParam != "" {
+		if expandWindow, err = parseWindowDuration(windowParam); err != nil {
+			return FilterOptions{}, err
+		}
+	}
+	now := time.Now().In(filterLoc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, filterLoc)
+	expandFrom := today
+	expandTo := today.Add(expandWindow)
+
+	if windowFromParam == "" {
+		windowFromParam = r.URL.Query().Get("from")
+	}
+	if windowToParam == "" {
+		windowToParam = r.URL.Query().Get("to")
+	}
+	windowFrom, windowTo, err := parseDateWindow(windowFromParam, windowToParam, filterLoc, today)
+	if err != nil {
+		return FilterOptions{}, err
+	}
+	// A bounded date window also sets the expansion window, unless window is given explicitly
+	if !windowFrom.IsZero() && !windowTo.IsZero() && r.URL.Query().Get("window") == "" {
+		expandFrom, expandTo = windowFrom, windowTo
+	}
+
+	if (minAttendees != nil && *minAttendees < 0) || (maxAttendees != nil && *maxAttendees < 0) {
+		return FilterOptions{}, fmt.Errorf("attendee limits must be non-negative")
+	}
+	if minAttendees != nil && maxAttendees != nil && *minAttendees > *maxAttendees {
+		return FilterOptions{}, fmt.Errorf("min_attendees (%d) must not exceed max_attendees (%d)", *minAttendees, *maxAttendees)
+	}
+
+	return FilterOptions{
+		TimeRanges:    filterRanges,
+		EffectiveFrom: effectiveFrom,
+		EffectiveTo:   effectiveTo,
+		WindowFrom:    windowFrom,
+		WindowTo:      windowTo,
+		DateRanges:    dateRanges,
+		Location:      filterLoc,
+		Match:         mode,
+		Tolerance:     tolerance,
+		Combine:       combine,
+		Invert:        invert,
+		Fold:          fold,
+		TitleContains: titleContains,
+		TitleRegex:    titleRegex,
+		AllDay:        allDay,
+		Transparency:  transparency,
+		Recurrence:    recurrence,
+
+		LocationContains: locationContains,
+		DescContains:     descContains,
+		Categories:       categories,
+		Classes:          classes,
+		EventTypes:       eventTypes,
+		UIDs:             uids,
+		Has:              has,
+		Missing:          missing,
+		MinAttendees:     minAttendees,
+		MaxAttendees:     maxAttendees,
+		MinDuration:      minDuration,
+		MaxDuration:      maxDuration,
+		KeepUnparseable:  keepUnparseable,
+		Dedupe:           dedupe,
+
+		Expand:     expand,
+		ExpandFrom: expandFrom,
+		ExpandTo:   expandTo,
+	}, nil
+}
+
+// Stats summarizes what Filter did to a calendar
+type Stats struct {
+	// Original is the number of events before filtering
+	Original int
+	// Removed lists the events that were filtered out, in calendar order
+	Removed []*ics.VEvent
+	// ExcludedOccurrences counts recurring event occurrences removed via EXDATE
+	ExcludedOccurrences int
+	// Occurrences counts individual occurrences within the expansion window; nil unless expanding
+	Occurrences *OccurrenceCounts
+	// Unparseable counts events whose start or end couldn't be determined, whether kept or dropped
+	Unparseable int
+	// Duplicates counts events dropped by Dedupe; they are not listed in Removed
+	Duplicates int
+	// Warnings describe the malformed properties met while filtering, one per problem
+	// The ics library parses leniently without reporting spec violations, so these are
+	// only the problems that surfaced when the filter read an event's properties
+	Warnings []string
+}
+
+// Filter builds a new calendar containing the events of cal that survive the filter options
+// When opts.Invert is set, only matching events are kept instead of removed
+// It does no I/O beyond logging warnings for unparseable events, and leaves cal unchanged
+// apart from EXDATE exclusions added to recurring events when expanding
+func Filter(cal *ics.Calendar, opts FilterOptions) (*ics.Calendar, Stats) {
+	// Create a new calendar with filtered events
+	filteredCal := ics.NewCalendar()
+
+	// Copy all calendar properties from original calendar
+	filteredCal.CalendarProperties = cal.CalendarProperties
+
+	stats := Stats{Original: len(cal.Events())}
+	var overrides map[string][]time.Time
+	if opts.Expand {
+		overrides = recurrenceOverrides(cal.Events())
+		stats.Occurrences = &OccurrenceCounts{}
+	}
+	// countOccurrence tallies a non-recurring event that falls within the expansion window
+	countOccurrence := func(eventStart time.Time, kept bool) {
+		if stats.Occurrences == nil ||
+			eventStart.Before(opts.ExpandFrom) || !eventStart.Before(opts.ExpandTo) {
+			return
+		}
+		stats.Occurrences.Original++
+		if kept {
+			stats.Occurrences.Filtered++
+		}
+	}
+
+	kept := make(map[*ics.VEvent]bool)
+	keep := func(event *ics.VEvent) {
+		kept[event] = true
+	}
+	remove := func(event *ics.VEvent) {
+		stats.Removed = append(stats.Removed, event)
+	}
+	// unparseable keeps or drops an event whose times can't be determined, per opts.KeepUnparseable
+	unparseable := func(event *ics.VEvent, which string, err error) {
+		stats.Unparseable++
+		stats.Warnings = append(stats.Warnings, fmt.Sprintf("event %s: failed to get %s time: %v", event.Id(), which, err))
+		if opts.KeepUnparseable {
+			log.Printf("Warning: failed to get %s time of event %s, passing it through unfiltered: %v", which, event.Id(), err)
+			keep(event)
+			return
+		}
+		log.Printf("Warning: failed to get %s time of event %s, dropping it: %v", which, event.Id(), err)
+		remove(event)
+	}
+
+	seen := make(map[string]bool)
+
+	// Filter events
+	for _, event := range cal.Events() {
+		if opts.Dedupe {
+			if key := dedupeKey(event); key != "" {
+				if seen[key] {
+					stats.Duplicates++
+					continue
+				}
+				seen[key] = true
+			}
+		}
+
+		// Apply the all-day mode before any time-based matching
+		allDay := isAllDayEvent(event)
+		if (opts.AllDay == allDayDrop && allDay) || (opts.AllDay == allDayOnly && !allDay) {
+			remove(event)
+			continue
+		}
+		if opts.Transparency != "" && isTransparentEvent(event) != (opts.Transparency == transparencyTransparent) {
+			remove(event)
+			continue
+		}
+		// Whole series are kept or dropped before expansion, so their occurrences go with them
+		if opts.Recurrence != "" && isPartOfSeries(event) != (opts.Recurrence == recurrenceRecurring) {
+			remove(event)
+			continue
+		}
+
+		eventStart, err := event.GetStartAt()
+		if err != nil {
+			unparseable(event, "start", err)
+			continue
+		}
+
+		// Drop events outside the date window before the matching rules run
+		if !inDateWindow(event, eventStart, opts, overrides[event.Id()]) {
+			remove(event)
+			continue
+		}
+
+		eventEnd, err := eventEndAt(event)
+		if err != nil {
+			unparseable(event, "end", err)
+			continue
+		}
+
+		// Evaluate recurring events occurrence by occurrence when expansion is enabled
+		if opts.Expand && isRecurringEvent(event) && !isRecurrenceOverride(event) {
+			outcome := filterRecurringEvent(event, eventStart, eventEnd, opts, overrides[event.Id()])
+			if outcome.err != nil {
+				log.Printf("Warning: failed to expand event %s, matching it as a whole: %v", event.Id(), outcome.err)
+				stats.Warnings = append(stats.Warnings, fmt.Sprintf("event %s: failed to expand recurrence: %v", event.Id(), outcome.err))
+			}
+			if outcome.handled {
+				stats.ExcludedOccurrences += outcome.excluded
+				stats.Occurrences.Original += outcome.occurrences
+				if outcome.removeEvent {
+					remove(event)
+				} else {
+					stats.Occurrences.Filtered += outcome.occurrences - outcome.excluded
+					keep(event)
+				}
+				continue
+			}
+		}
+
+		// If event matches the opts, skip it (or keep only matches when inverted)
+		if eventMatchesCriteria(event, eventStart, eventEnd, opts) != opts.Invert {
+			countOccurrence(eventStart, false)
+			remove(event)
+			continue
+		}
+
+		// Add event to filtered calendar
+		countOccurrence(eventStart, true)
+		keep(event)
+	}
+
+	// Rebuild the components in their original order, carrying over VTIMEZONE and
+	// other non-event components unchanged so floating and TZID times still resolve
+	for _, component := range cal.Components {
+		if event, ok := component.(*ics.VEvent); ok && !kept[event] {
+			continue
+		}
+		filteredCal.Components = append(filteredCal.Components, component)
+	}
+
+	return filteredCal, stats
+}
+
+// dedupeKey identifies an event for Dedupe: its UID, plus the RECURRENCE-ID for overridden
+// occurrences so they aren't mistaken for duplicates of their series. Events without a UID
+// have no key and are never treated as duplicates
+func dedupeKey(event *ics.VEvent) string {
+	uid := event.Id()
+	if uid == "" {
+		return ""
+	}
+	if prop := event.GetProperty(componentPropertyRecurrenceID); prop != nil {
+		return uid + "\x00" + prop.Value
+	}
+	return uid
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// parseTestCalendar parses a calendar made of the given VEVENT bodies, one per argument
+func parseTestCalendar(t testing.TB, events ...string) *ics.Calendar {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//cal-filter//test//EN\r\n")
+	for _, event := range events {
+		b.WriteString("BEGIN:VEVENT\r\n")
+		b.WriteString(strings.ReplaceAll(strings.TrimSpace(event), "\n", "\r\n"))
+		b.WriteString("\r\nEND:VEVENT\r\n")
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+	cal, err := ics.ParseCalendar(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("failed to parse test calendar: %v", err)
+	}
+	return cal
+}
+
+// mustTimeRange builds a TimeRange from HH:MM boundaries
+func mustTimeRange(t testing.TB, start, end string) TimeRange {
+	t.Helper()
+	startTime, err := parseTimeOfDay(start)
+	if err != nil {
+		t.Fatalf("parseTimeOfDay(%q): %v", start, err)
+	}
+	endTime, err := parseTimeOfDay(end)
+	if err != nil {
+		t.Fatalf("parseTimeOfDay(%q): %v", end, err)
+	}
+	return TimeRange{Start: startTime, End: endTime}
+}
+
+// testOptions returns the options a request with the given ranges and no other parameters gets
+func testOptions(ranges ...TimeRange) FilterOptions {
+	return FilterOptions{
+		TimeRanges:      ranges,
+		Location:        time.UTC,
+		Match:           matchExact,
+		Combine:         combineOr,
+		KeepUnparseable: defaultKeepUnparseable,
+	}
+}
+
+// keptUIDs returns the UIDs of the events in cal, in order
+func keptUIDs(cal *ics.Calendar) []string {
+	var uids []string
+	for _, event := range cal.Events() {
+		uids = append(uids, event.Id())
+	}
+	return uids
+}
+
+func TestFilterKeepsAllDayEvents(t *testing.T) {
+	cal := parseTestCalendar(t,
+		"UID:birthday\nSUMMARY:Birthday\nDTSTART;VALUE=DATE:20240105\nDTEND;VALUE=DATE:20240106",
+		"UID:standup\nSUMMARY:Standup\nDTSTART:20240105T090000Z\nDTEND:20240105T091500Z",
+	)
+
+	filtered, stats := Filter(cal, testOptions(mustTimeRange(t, "09:00", "09:15")))
+
+	if got := keptUIDs(filtered); len(got) != 1 || got[0] != "birthday" {
+		t.Fatalf("kept events = %v, want [birthday]", got)
+	}
+	if len(stats.Removed) != 1 || stats.Removed[0].Id() != "standup" {
+		t.Errorf("removed %d events, want only standup", len(stats.Removed))
+	}
+}
+
+func TestFilterUnparseableEvents(t *testing.T) {
+	cal := parseTestCalendar(t, "UID:broken\nSUMMARY:Broken\nDTSTART:not-a-date\nDTEND:20240105T100000Z")
+
+	opts := testOptions(mustTimeRange(t, "09:00", "10:00"))
+	filtered, stats := Filter(cal, opts)
+	if got := keptUIDs(filtered); len(got) != 1 {
+		t.Errorf("kept events = %v, want the unparseable event passed through", got)
+	}
+	if stats.Unparseable != 1 {
+		t.Errorf("Unparseable = %d, want 1", stats.Unparseable)
+	}
+
+	opts.KeepUnparseable = false
+	filtered, _ = Filter(cal, opts)
+	if got := keptUIDs(filtered); len(got) != 0 {
+		t.Errorf("kept events = %v with KeepUnparseable off, want none", got)
+	}
+}
+
+func TestFilterKeepsTimezones(t *testing.T) {
+	data := strings.ReplaceAll(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//cal-filter//test//EN
+BEGIN:VTIMEZONE
+TZID:America/New_York
+BEGIN:STANDARD
+DTSTART:19701101T020000
+RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+TZNAME:EST
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:19700308T020000
+RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU
+TZOFFSETFROM:-0500
+TZOFFSETTO:-0400
+TZNAME:EDT
+END:DAYLIGHT
+END:VTIMEZONE
+BEGIN:VEVENT
+UID:standup
+DTSTART;TZID=America/New_York:20240105T090000
+DTEND;TZID=America/New_York:20240105T091500
+END:VEVENT
+BEGIN:VEVENT
+UID:lunch
+DTSTART;TZID=America/New_York:20240105T120000
+DTEND;TZID=America/New_York:20240105T130000
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n")
+	cal, err := ics.ParseCalendar(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to parse test calendar: %v", err)
+	}
+
+	filtered, _ := Filter(cal, testOptions(mustTimeRange(t, "14:00", "14:15")))
+
+	if got := keptUIDs(filtered); len(got) != 1 || got[0] != "lunch" {
+		t.Fatalf("kept events = %v, want [lunch]", got)
+	}
+	if len(filtered.Components) == 0 {
+		t.Fatal("filtered calendar has no components")
+	}
+	timezone, ok := filtered.Components[0].(*ics.VTimezone)
+	if !ok {
+		t.Fatalf("first component is %T, want the VTIMEZONE", filtered.Components[0])
+	}
+	if prop := timezone.GetProperty(ics.ComponentProperty("TZID")); prop == nil || prop.Value != "America/New_York" {
+		t.Errorf("VTIMEZONE lost its TZID")
+	}
+	if !strings.Contains(filtered.Serialize(), "BEGIN:DAYLIGHT") {
+		t.Errorf("serialized calendar lost the VTIMEZONE rules")
+	}
+}
+
+func TestFilterStartOnly(t *testing.T) {
+	cal := parseTestCalendar(t,
+		"UID:long-standup\nDTSTART:20240105T090000Z\nDTEND:20240105T110000Z",
+		"UID:short-standup\nDTSTART:20240105T090000Z\nDTEND:20240105T091500Z",
+		"UID:early\nDTSTART:20240105T083000Z\nDTEND:20240105T093000Z",
+		"UID:late\nDTSTART:20240105T091500Z\nDTEND:20240105T100000Z",
+	)
+	opts := testOptions(mustTimeRange(t, "09:00", "10:00"))
+	opts.Match = matchStartOnly
+
+	filtered, stats := Filter(cal, opts)
+
+	// Events starting at the range start are removed whatever their end; ones starting at
+	// any other time, before the range or later within it, are kept
+	if got := strings.Join(keptUIDs(filtered), ","); got != "early,late" {
+		t.Errorf("kept events = %s, want early,late", got)
+	}
+	if len(stats.Removed) != 2 {
+		t.Errorf("removed %d events, want 2", len(stats.Removed))
+	}
+
+	opts.Match = matchExact
+	filtered, _ = Filter(cal, opts)
+	if got := strings.Join(keptUIDs(filtered), ","); got != "long-standup,short-standup,early,late" {
+		t.Errorf("exact match kept %s, want every event", got)
+	}
+}
+
+func TestParseFilterOptionsEmptyJSONRanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantRanges int
+	}{
+		// An explicit empty list means no range filtering, overriding the query
+		{"empty time_ranges", `{"time_ranges": []}`, 0},
+		// Without time_ranges in the body the query ranges apply
+		{"no JSON", "", 1},
+		{"JSON without ranges", `{"invert": false}`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/filter?ranges=09:00-10:00", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			opts, err := parseFilterOptions(r)
+			if err != nil {
+				t.Fatalf("parseFilterOptions: %v", err)
+			}
+			if len(opts.TimeRanges) != tt.wantRanges {
+				t.Errorf("got %d time ranges, want %d", len(opts.TimeRanges), tt.wantRanges)
+			}
+		})
+	}
+}
+
+func TestParseFilterOptionsRejectsReversedJSONRanges(t *testing.T) {
+	for _, body := range []string{
+		`{"time_ranges": [{"start": "2024-01-01T10:00:00Z", "end": "2024-01-01T09:00:00Z"}]}`,
+		`{"time_ranges": [{"start": "2024-01-01T23:00:00Z", "end": "2024-01-02T01:00:00Z"}]}`,
+		`{"weekday_ranges": {"mon": [{"start": "2024-01-01T09:00:00Z", "end": "2024-01-01T09:00:00Z"}]}}`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/filter", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if _, err := parseFilterOptions(r); err == nil || !strings.Contains(err.Error(), "start time must be before end time") {
+			t.Errorf("parseFilterOptions(%s) error = %v, want a range order error", body, err)
+		}
+	}
+}
+
+func BenchmarkFilter(b *testing.B) {
+	// Half the events match the range exactly, the rest are an hour later
+	events := make([]string, 1000)
+	day := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	for i := range events {
+		start := day.AddDate(0, 0, i/2).Add(time.Duration(i%2) * time.Hour)
+		events[i] = fmt.Sprintf("UID:event-%d\nSUMMARY:Event %d\nDTSTART:%s\nDTEND:%s",
+			i, i, start.Format("20060102T150405Z"), start.Add(30*time.Minute).Format("20060102T150405Z"))
+	}
+	cal := parseTestCalendar(b, events...)
+	opts := testOptions(mustTimeRange(b, "09:00", "09:30"))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Filter(cal, opts)
+	}
+}
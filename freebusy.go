@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// freeBusyTimeFormat is the UTC date-time format used in VFREEBUSY
+const freeBusyTimeFormat = "20060102T150405Z"
+
+// busyPeriod is a single busy interval
+type busyPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// busyPeriods computes the merged busy intervals of events within [from, to)
+// Recurring events contribute each occurrence in the window; transparent events are free time
+// Overlapping and touching intervals are merged
+func busyPeriods(events []*ics.VEvent, from, to time.Time) []busyPeriod {
+	overrides := recurrenceOverrides(events)
+
+	var periods []busyPeriod
+	addPeriod := func(start, end time.Time) {
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if start.Before(end) {
+			periods = append(periods, busyPeriod{Start: start, End: end})
+		}
+	}
+
+	for _, event := range events {
+		if isTransparentEvent(event) {
+			continue
+		}
+		eventStart, err := event.GetStartAt()
+		if err != nil {
+			continue
+		}
+		eventEnd, err := eventEndAt(event)
+		if err != nil {
+			continue
+		}
+
+		if isRecurringEvent(event) && !isRecurrenceOverride(event) {
+			duration := eventEnd.Sub(eventStart)
+			// Start the search one duration early so occurrences running into the window count
+			occurrences, err := recurrenceOccurrences(event, eventStart, from.Add(-duration), to, overrides[event.Id()])
+			if err != nil {
+				log.Printf("Warning: failed to expand recurring event %s for free/busy: %v", event.Id(), err)
+				addPeriod(eventStart, eventEnd)
+				continue
+			}
+			for _, occurrence := range occurrences {
+				addPeriod(occurrence, occurrence.Add(duration))
+			}
+			continue
+		}
+		addPeriod(eventStart, eventEnd)
+	}
+
+	sort.Slice(periods, func(i, j int) bool {
+		return periods[i].Start.Before(periods[j].Start)
+	})
+
+	var merged []busyPeriod
+	for _, period := range periods {
+		if last := len(merged) - 1; last >= 0 && !period.Start.After(merged[last].End) {
+			if period.End.After(merged[last].End) {
+				merged[last].End = period.End
+			}
+			continue
+		}
+		merged = append(merged, period)
+	}
+	return merged
+}
+
+// writeFreeBusy writes the surviving events as a single VFREEBUSY covering [from, to)
+func writeFreeBusy(w http.ResponseWriter, result filterResult, from, to time.Time) {
+	periods := busyPeriods(result.Kept, from, to)
+
+	// The iCal library doesn't serialize VFREEBUSY correctly, so the component is written directly
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//calendar-filter//Free Busy//EN")
+	line("METHOD:PUBLISH")
+	line("BEGIN:VFREEBUSY")
+	line("UID:freebusy-%d-%d@calendar-filter", from.Unix(), to.Unix())
+	line("DTSTAMP:%s", time.Now().UTC().Format(freeBusyTimeFormat))
+	line("DTSTART:%s", from.UTC().Format(freeBusyTimeFormat))
+	line("DTEND:%s", to.UTC().Format(freeBusyTimeFormat))
+	for _, period := range periods {
+		line("FREEBUSY;FBTYPE=BUSY:%s/%s", period.Start.UTC().Format(freeBusyTimeFormat), period.End.UTC().Format(freeBusyTimeFormat))
+	}
+	line("END:VFREEBUSY")
+	line("END:VCALENDAR")
+
+	setOccurrenceHeaders(w, result)
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// mergeAdjacentEvents replaces kept events that overlap or touch with single "Busy" events
+// Only timed, non-recurring events are merged; recurring series, their overrides and
+// all-day events are left untouched. Events that don't touch any other keep their details
+func mergeAdjacentEvents(result *filterResult) {
+	type candidate struct {
+		event      *ics.VEvent
+		start, end time.Time
+	}
+	var candidates []candidate
+	for _, event := range result.Kept {
+		if isRecurringEvent(event) || isRecurrenceOverride(event) || isAllDayEvent(event) {
+			continue
+		}
+		start, err := event.GetStartAt()
+		if err != nil {
+			continue
+		}
+		end, err := eventEndAt(event)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{event: event, start: start, end: end})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].start.Before(candidates[j].start)
+	})
+
+	// Group candidates into runs of overlapping or touching events
+	var groups [][]candidate
+	var groupEnd time.Time
+	for _, c := range candidates {
+		if last := len(groups) - 1; last >= 0 && !c.start.After(groupEnd) {
+			groups[last] = append(groups[last], c)
+			if c.end.After(groupEnd) {
+				groupEnd = c.end
+			}
+			continue
+		}
+		groups = append(groups, []candidate{c})
+		groupEnd = c.end
+	}
+
+	// replacements maps the first event of each merged run to its block; later members map to nil
+	replacements := make(map[*ics.VEvent]*ics.VEvent)
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		start, end := group[0].start, group[0].end
+		for _, c := range group[1:] {
+			if c.end.After(end) {
+				end = c.end
+			}
+		}
+		block := ics.NewEvent(fmt.Sprintf("busy-%d-%d@calendar-filter", start.Unix(), end.Unix()))
+		block.SetDtStampTime(time.Now())
+		block.SetStartAt(start)
+		block.SetEndAt(end)
+		block.SetSummary(busySummary)
+
+		first := group[0].event
+		replacements[first] = block
+		for _, c := range group[1:] {
+			replacements[c.event] = nil
+		}
+	}
+	if len(replacements) == 0 {
+		return
+	}
+
+	// Rebuild the calendar and kept list, putting each block where its first event was
+	components := result.Calendar.Components[:0]
+	for _, component := range result.Calendar.Components {
+		event, ok := component.(*ics.VEvent)
+		if !ok {
+			components = append(components, component)
+			continue
+		}
+		replacement, replaced := replacements[event]
+		if !replaced {
+			components = append(components, component)
+		} else if replacement != nil {
+			components = append(components, replacement)
+		}
+	}
+	result.Calendar.Components = components
+
+	kept := make([]*ics.VEvent, 0, len(result.Kept))
+	for _, event := range result.Kept {
+		replacement, replaced := replacements[event]
+		if !replaced {
+			kept = append(kept, event)
+		} else if replacement != nil {
+			kept = append(kept, replacement)
+		}
+	}
+	result.Kept = kept
+}
@@ -2,4 +2,21 @@ module cal-filter
 
 go 1.21
 
-require github.com/arran4/golang-ical v0.1.0
+require (
+	github.com/arran4/golang-ical v0.1.0
+	github.com/prometheus/client_golang v1.19.0
+	github.com/teambition/rrule-go v1.8.2
+	golang.org/x/sync v0.7.0
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+)
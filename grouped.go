@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// unmatchedGroup is the grouped-output key for events that match none of the time ranges
+const unmatchedGroup = "unmatched"
+
+// GroupedSummary is the JSON output of format=grouped
+// Groups maps each time range (e.g. "09:00-10:00" or "09:00-10:00 mon,wed") to the events
+// matching it, plus an "unmatched" group; an event matching several ranges is in each of them
+type GroupedSummary struct {
+	OriginalCount int                       `json:"original_count"`
+	Groups        map[string][]EventSummary `json:"groups"`
+}
+
+// rangeLabel names a time range in grouped output
+func rangeLabel(r TimeRange) string {
+	layout := "15:04"
+	if r.WithSeconds {
+		layout = "15:04:05"
+	}
+	label := r.Start.Format(layout) + "-" + r.End.Format(layout)
+	if len(r.Days) > 0 {
+		label += " " + strings.ToLower(strings.Join(r.Days, ","))
+	}
+	return label
+}
+
+// groupEventsByRange assigns every event, kept or removed, to the time ranges it matches,
+// using each range's match mode and the effective window. Other filters don't affect grouping.
+// Events with unparseable times, or matching no range, are grouped as unmatched
+func groupEventsByRange(result filterResult, criteria FilterOptions) GroupedSummary {
+	events := make([]*ics.VEvent, 0, len(result.Kept)+len(result.Removed))
+	events = append(events, result.Kept...)
+	events = append(events, result.Removed...)
+	sortEventsByStart(events)
+
+	groups := map[string][]EventSummary{unmatchedGroup: {}}
+	for _, filterRange := range criteria.TimeRanges {
+		groups[rangeLabel(filterRange)] = []EventSummary{}
+	}
+
+	for _, event := range events {
+		matched := false
+		eventStart, startErr := event.GetStartAt()
+		eventEnd, endErr := eventEndAt(event)
+		if startErr == nil && endErr == nil && inEffectiveWindow(eventStart, criteria) {
+			for _, filterRange := range criteria.TimeRanges {
+				mode := rangeMatchMode(filterRange, criteria.Match)
+				if eventMatchesRange(eventStart, eventEnd, filterRange, criteria.Location, mode, criteria.Tolerance) {
+					label := rangeLabel(filterRange)
+					groups[label] = append(groups[label], summarizeEvent(event))
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			groups[unmatchedGroup] = append(groups[unmatchedGroup], summarizeEvent(event))
+		}
+	}
+
+	return GroupedSummary{OriginalCount: result.OriginalCount, Groups: groups}
+}
+
+// writeGrouped writes the events of a filter result grouped by the time ranges they match
+func writeGrouped(w http.ResponseWriter, result filterResult, criteria FilterOptions) {
+	setOccurrenceHeaders(w, result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(groupEventsByRange(result, criteria))
+}
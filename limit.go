@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// pageOptions caps the returned events to a window of the chronologically sorted results
+type pageOptions struct {
+	// Limit is the maximum number of events to return; nil means no limit
+	Limit *int
+	// Offset is the number of earliest events to skip
+	Offset int
+}
+
+// active reports whether the options restrict the output at all
+func (p pageOptions) active() bool {
+	return p.Limit != nil || p.Offset > 0
+}
+
+// parsePageOptions parses the limit and offset query parameters
+func parsePageOptions(r *http.Request) (pageOptions, error) {
+	limit, err := parseCountParam(r, "limit")
+	if err != nil {
+		return pageOptions{}, err
+	}
+	offset, err := parseCountParam(r, "offset")
+	if err != nil {
+		return pageOptions{}, err
+	}
+	options := pageOptions{Limit: limit}
+	if offset != nil {
+		options.Offset = *offset
+	}
+	return options, nil
+}
+
+// sortByStart orders the returned events by start time
+const sortByStart = "start"
+
+// parseSortOrder parses the sort query parameter; empty keeps the calendar's original order
+func parseSortOrder(r *http.Request) (string, error) {
+	value := r.URL.Query().Get("sort")
+	order := strings.ToLower(strings.TrimSpace(value))
+	switch order {
+	case "", sortByStart:
+		return order, nil
+	default:
+		return "", fmt.Errorf("invalid sort: %s (expected %s)", value, sortByStart)
+	}
+}
+
+// sortKeptEvents reorders the kept events (and the calendar) ascending by start time
+func sortKeptEvents(result *filterResult) {
+	events := append([]*ics.VEvent(nil), result.Kept...)
+	sortEventsByStart(events)
+	replaceEvents(result, events)
+}
+
+// sortEventsByStart sorts events ascending by DTSTART, keeping the original order for ties
+// Events whose start can't be parsed are placed last
+func sortEventsByStart(events []*ics.VEvent) {
+	type sortable struct {
+		event *ics.VEvent
+		start int64
+		ok    bool
+	}
+	items := make([]sortable, len(events))
+	for i, event := range events {
+		items[i].event = event
+		if start, err := event.GetStartAt(); err == nil {
+			items[i].start = start.UnixNano()
+			items[i].ok = true
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].ok != items[j].ok {
+			return items[i].ok
+		}
+		return items[i].start < items[j].start
+	})
+	for i, item := range items {
+		events[i] = item.event
+	}
+}
+
+// replaceEvents sets the kept events of a result, in the given order
+// Events take the place of the first original event in the calendar; other components stay where they were
+func replaceEvents(result *filterResult, events []*ics.VEvent) {
+	components := make([]ics.Component, 0, len(result.Calendar.Components))
+	placed := false
+	for _, component := range result.Calendar.Components {
+		if _, ok := component.(*ics.VEvent); !ok {
+			components = append(components, component)
+			continue
+		}
+		if !placed {
+			for _, event := range events {
+				components = append(components, event)
+			}
+			placed = true
+		}
+	}
+	if !placed {
+		for _, event := range events {
+			components = append(components, event)
+		}
+	}
+	result.Calendar.Components = components
+	result.Kept = events
+}
+
+// applyPageOptions keeps only the events in the requested slice of the results sorted by start time
+func applyPageOptions(result *filterResult, options pageOptions) {
+	if !options.active() {
+		return
+	}
+	sortKeptEvents(result)
+	events := result.Kept
+	if options.Offset >= len(events) {
+		events = events[:0]
+	} else {
+		events = events[options.Offset:]
+	}
+	if options.Limit != nil && *options.Limit < len(events) {
+		events = events[:*options.Limit]
+	}
+	replaceEvents(result, events)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Log formats selected with LOG_FORMAT
+const (
+	// logFormatText is the default log.Printf style output
+	logFormatText = "text"
+	// logFormatJSON emits one structured JSON object per line
+	logFormatJSON = "json"
+)
+
+// jsonLogging is set in main when LOG_FORMAT=json
+var jsonLogging bool
+
+// setupLogging configures the logger from LOG_FORMAT
+// In JSON mode, existing log.Printf output is routed through slog as well
+func setupLogging() error {
+	format := strings.ToLower(getEnv("LOG_FORMAT", logFormatText))
+	switch format {
+	case logFormatText:
+		jsonLogging = false
+	case logFormatJSON:
+		jsonLogging = true
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT: %s (expected text or json)", format)
+	}
+	return nil
+}
+
+// requestIDHeader carries the ID used to correlate a request's log lines across proxies
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength caps incoming request IDs; longer or non-printable ones are replaced
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID tags each request with the incoming X-Request-ID, or a generated UUID,
+// and echoes it back in the response headers
+func withRequestID(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID reports whether an incoming request ID is safe to log and echo
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random version 4 UUID
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// requestID returns the ID attached to a request by withRequestID, or an empty string
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// trustProxy is set in main when TRUST_PROXY=true, meaning the service runs behind a
+// reverse proxy whose client address headers can be believed
+var trustProxy bool
+
+// clientAddr returns the address of the client that made a request, for logs
+// Behind a trusted proxy this is X-Real-IP, or else the last X-Forwarded-For entry (the one
+// the proxy appended, since earlier entries can be supplied by the client); otherwise it
+// is the connection's remote address
+func clientAddr(r *http.Request) string {
+	if !trustProxy {
+		return r.RemoteAddr
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		entries := strings.Split(values[len(values)-1], ",")
+		if last := strings.TrimSpace(entries[len(entries)-1]); last != "" {
+			return last
+		}
+	}
+	return r.RemoteAddr
+}
+
+// requestLabel identifies a request in text log lines by client address and request ID
+func requestLabel(r *http.Request) string {
+	if id := requestID(r); id != "" {
+		return clientAddr(r) + " " + id
+	}
+	return clientAddr(r)
+}
+
+// requestStats collects per-request details for the structured request log
+type requestStats struct {
+	counted       bool
+	originalCount int
+	filteredCount int
+	source        string
+	expand        bool
+	expandFrom    time.Time
+	expandTo      time.Time
+}
+
+type requestStatsKey struct{}
+
+// statsForRequest returns the stats attached to a request by logRequests, or nil
+func statsForRequest(r *http.Request) *requestStats {
+	stats, _ := r.Context().Value(requestStatsKey{}).(*requestStats)
+	return stats
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(data)
+}
+
+// logRequests emits a structured log line for every request when JSON logging is enabled
+func logRequests(name string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !jsonLogging {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		stats := &requestStats{}
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestStatsKey{}, stats)))
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []any{
+			"handler", name,
+			"method", r.Method,
+			"remote_addr", clientAddr(r),
+			"request_id", requestID(r),
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		}
+		if stats.counted {
+			attrs = append(attrs,
+				"original_count", stats.originalCount,
+				"filtered_count", stats.filteredCount,
+				"removed", stats.originalCount-stats.filteredCount,
+			)
+		}
+		if stats.source != "" {
+			attrs = append(attrs, "calendar_source", stats.source)
+		}
+		if stats.expand {
+			attrs = append(attrs,
+				"expand_from", stats.expandFrom.Format("2006-01-02"),
+				"expand_to", stats.expandTo.Format("2006-01-02"),
+			)
+		}
+		slog.Info("request", attrs...)
+	})
+}
@@ -1,23 +1,120 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode"
 
 	ics "github.com/arran4/golang-ical"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/text/unicode/norm"
 )
 
 const (
 	defaultPort = "8080"
+
+	// defaultFetchTimeout bounds each upstream calendar request
+	defaultFetchTimeout = 10 * time.Second
+	// defaultMaxCalendarBytes caps upstream calendar bodies unless MAX_CALENDAR_BYTES is set
+	defaultMaxCalendarBytes = 50 << 20
+	// fetchAttempts is how many times a failed upstream fetch is tried
+	fetchAttempts = 3
+	// fetchBackoff is the delay before the first retry; it doubles on each retry, plus up to
+	// half again of random jitter so instances retrying together spread out
+	fetchBackoff = 500 * time.Millisecond
+
+	// shutdownTimeout bounds how long in-flight requests may run after SIGINT/SIGTERM
+	shutdownTimeout = 30 * time.Second
+
+	// defaultReadTimeout bounds reading a request, including its headers
+	defaultReadTimeout = 15 * time.Second
+	// defaultWriteTimeout bounds handling a request; it covers a retried upstream fetch plus filtering
+	defaultWriteTimeout = 60 * time.Second
+	// defaultIdleTimeout bounds how long keep-alive connections wait for the next request
+	defaultIdleTimeout = 120 * time.Second
+)
+
+// httpClient is used for all upstream calendar requests, configured in main
+var httpClient = newFetchClient(defaultFetchTimeout)
+
+// maxCalendarBytes is the largest upstream calendar body the service will read, configured in main
+var maxCalendarBytes int64 = defaultMaxCalendarBytes
+
+// defaultLocation is the filter timezone for requests that don't name one, configured in main
+// from DEFAULT_TZ; it is the server's local timezone when unset
+var defaultLocation = time.Local
+
+// defaultRanges are applied to requests that provide no ranges of their own, configured in main
+// from DEFAULT_RANGES; nil when unset
+var defaultRanges []TimeRange
+
+// Match modes control how an event is compared against the filter ranges
+const (
+	// matchExact removes events whose start and end times equal a filter range
+	matchExact = "exact"
+	// matchOverlap removes events that overlap a filter range at all
+	matchOverlap = "overlap"
+	// matchContain removes events that fall entirely within a single filter range
+	matchContain = "contain"
+	// matchStartOnly removes events whose start time equals a filter range's start, whatever their end
+	matchStartOnly = "start_only"
+)
+
+// Combine modes control how the active filters are combined for an event
+const (
+	// combineOr removes an event if any active filter matches it
+	combineOr = "or"
+	// combineAnd removes an event only if every active filter matches it
+	combineAnd = "and"
+)
+
+// All-day modes control how all-day events are handled
+const (
+	// allDayKeep passes all-day events through the normal filters
+	allDayKeep = "keep"
+	// allDayDrop removes all-day events
+	allDayDrop = "drop"
+	// allDayOnly removes everything except all-day events
+	allDayOnly = "only"
 )
 
+// Transparency modes select events by their TRANSP property
+const (
+	// transparencyOpaque keeps only events that block time (the default when TRANSP is missing)
+	transparencyOpaque = "opaque"
+	// transparencyTransparent keeps only events marked as free time
+	transparencyTransparent = "transparent"
+)
+
+// Recurrence modes select recurring series or one-off events
+const (
+	// recurrenceRecurring keeps only recurring series and their overridden occurrences
+	recurrenceRecurring = "recurring"
+	// recurrenceSingle keeps only one-off events
+	recurrenceSingle = "single"
+)
+
+// defaultCalendarName is the name given to a CALENDAR_URL that is a single plain URL
+const defaultCalendarName = "default"
+
 // getCalendarURL returns the calendar URL from environment variable
 // Returns an error if CALENDAR_URL is not set
 func getCalendarURL() (string, error) {
@@ -28,37 +125,217 @@ func getCalendarURL() (string, error) {
 	return url, nil
 }
 
+// getCalendarURLs returns the configured calendars keyed by name
+// CALENDAR_URL may be a single URL, a comma-separated list of name=url pairs, or a JSON object of name to URL
+func getCalendarURLs() (map[string]string, error) {
+	raw, err := getCalendarURL()
+	if err != nil {
+		return nil, err
+	}
+	return parseCalendarURLs(raw)
+}
+
+// isConfiguredCalendarURL reports whether calendarURL (already normalized) is one of the calendars in CALENDAR_URL
+func isConfiguredCalendarURL(calendarURL string) bool {
+	calendars, err := getCalendarURLs()
+	if err != nil {
+		return false
+	}
+	for _, configured := range calendars {
+		if normalizeCalendarURL(configured) == calendarURL {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCalendarURLs parses a CALENDAR_URL value into calendars keyed by name
+// Formats: "https://..." (named "default"), "work=https://...,home=https://...", or {"work": "https://..."}
+func parseCalendarURLs(raw string) (map[string]string, error) {
+	raw = strings.TrimSpace(raw)
+	calendars := make(map[string]string)
+
+	if strings.HasPrefix(raw, "{") {
+		if err := json.Unmarshal([]byte(raw), &calendars); err != nil {
+			return nil, fmt.Errorf("invalid CALENDAR_URL JSON: %w", err)
+		}
+		if len(calendars) == 0 {
+			return nil, fmt.Errorf("CALENDAR_URL JSON must define at least one calendar")
+		}
+		return calendars, nil
+	}
+
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// A name=url pair has a plain name before the first '='; a bare URL has "://" there
+		name, url, found := strings.Cut(entry, "=")
+		if !found || strings.ContainsAny(name, ":/") {
+			if len(calendars) > 0 || strings.Contains(raw, ",") {
+				return nil, fmt.Errorf("invalid CALENDAR_URL entry: %s (expected name=url)", entry)
+			}
+			calendars[defaultCalendarName] = entry
+			continue
+		}
+
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("invalid CALENDAR_URL entry: %s (missing name)", entry)
+		}
+		if _, exists := calendars[name]; exists {
+			return nil, fmt.Errorf("duplicate calendar name in CALENDAR_URL: %s", name)
+		}
+		calendars[name] = strings.TrimSpace(url)
+	}
+
+	if len(calendars) == 0 {
+		return nil, fmt.Errorf("CALENDAR_URL environment variable is required")
+	}
+	return calendars, nil
+}
+
+// calendarNames returns the sorted names of the configured calendars
+func calendarNames(calendars map[string]string) []string {
+	names := make([]string, 0, len(calendars))
+	for name := range calendars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveCalendarURL selects the calendar URL for a request by name
+// An empty name is only allowed when exactly one calendar is configured
+func resolveCalendarURL(calendars map[string]string, name string) (string, error) {
+	if name == "" {
+		if len(calendars) == 1 {
+			for _, url := range calendars {
+				return url, nil
+			}
+		}
+		return "", fmt.Errorf("multiple calendars configured, select one with cal= (valid names: %s)",
+			strings.Join(calendarNames(calendars), ", "))
+	}
+
+	url, ok := calendars[name]
+	if !ok {
+		return "", fmt.Errorf("unknown calendar: %s (valid names: %s)", name, strings.Join(calendarNames(calendars), ", "))
+	}
+	return url, nil
+}
+
 // TimeRange represents a start and end time for filtering
+// Days optionally restricts the range to specific weekdays (e.g. "mon", "wed"); empty means every day
+// WithSeconds compares seconds as well as hours and minutes; otherwise matching is minute-precision
+// Match optionally overrides the request's match mode for this range alone
 type TimeRange struct {
-	Start time.Time `json:"start"`
-	End   time.Time `json:"end"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Days        []string  `json:"days,omitempty"`
+	WithSeconds bool      `json:"with_seconds,omitempty"`
+	Match       string    `json:"match,omitempty"`
+}
+
+// weekdayNames maps accepted day names to weekdays
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// DateRange represents an absolute start and end date-time for filtering
+type DateRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// DateRangeRequest represents an absolute date range in a JSON request
+// Start and end use the YYYY-MM-DDTHH:MM format and are parsed in the filter timezone
+type DateRangeRequest struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
 }
 
 // FilterRequest represents the request body for filtering
 type FilterRequest struct {
-	TimeRanges []TimeRange `json:"time_ranges"`
+	TimeRanges    []TimeRange            `json:"time_ranges"`
+	WeekdayRanges map[string][]TimeRange `json:"weekday_ranges"`
+	DateRanges    []DateRangeRequest     `json:"date_ranges"`
+	Invert        bool                   `json:"invert"`
+	Fold          bool                   `json:"fold"`
+	Timezone      string                 `json:"timezone"`
+	TitleContains []string               `json:"title_contains"`
+	TitleRegex    []string               `json:"title_regex"`
+	AllDay        string                 `json:"all_day"`
+	Transparency  string                 `json:"transparency"`
+	Recurrence    string                 `json:"recurrence"`
+
+	LocationContains []string `json:"location_contains"`
+	DescContains     []string `json:"desc_contains"`
+	Categories       []string `json:"categories"`
+	Classes          []string `json:"classes"`
+	EventTypes       []string `json:"event_types"`
+	UIDs             []string `json:"uids"`
+	Has              []string `json:"has"`
+	Missing          []string `json:"missing"`
+	MinAttendees     *int     `json:"min_attendees"`
+	MaxAttendees     *int     `json:"max_attendees"`
+	MinDuration      string   `json:"min_duration"`
+	MaxDuration      string   `json:"max_duration"`
+	EffectiveFrom    string   `json:"effective_from"`
+	EffectiveTo      string   `json:"effective_to"`
+	From             string   `json:"from"`
+	To               string   `json:"to"`
 }
 
 // parseTimeRangesFromQuery parses time ranges from query parameters
 // Supports two formats:
 // 1. ranges=HH:MM-HH:MM,HH:MM-HH:MM (comma-separated list of start-end pairs)
 // 2. start=HH:MM&end=HH:MM&start=HH:MM&end=HH:MM (repeating pairs)
-// Timezone can be specified via tz parameter (e.g., tz=America/New_York) or defaults to local time
+// Minute-of-day ranges (ranges_minutes=540-600) are added to either format
+// Timezone can be specified via tz parameter (e.g., tz=America/New_York) or defaults to defaultLocation
 func parseTimeRangesFromQuery(r *http.Request) ([]TimeRange, *time.Location, error) {
-	// Get timezone from query parameter or default to local time
-	loc := time.UTC
+	// Get timezone from query parameter or fall back to the default timezone
+	loc := defaultLocation
 	if tzParam := r.URL.Query().Get("tz"); tzParam != "" {
 		var err error
-		loc, err = time.LoadLocation(tzParam)
+		loc, err = loadTimezone(tzParam)
 		if err != nil {
-			return nil, nil, fmt.Errorf("invalid timezone: %s (error: %w)", tzParam, err)
+			return nil, nil, fmt.Errorf("invalid timezone: %s (%w)", tzParam, err)
 		}
 	}
 
+	// Optional weekday restriction applied to every range: days=mon,wed,fri
+	days, err := parseDaysList(r.URL.Query().Get("days"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	minuteRanges, err := parseMinuteRangesList(r.URL.Query().Get("ranges_minutes"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range minuteRanges {
+		minuteRanges[i].Days = days
+	}
+
 	// Try the simpler ranges format first: ranges=09:00-10:00,14:00-15:00
 	if rangesParam := r.URL.Query().Get("ranges"); rangesParam != "" {
-		ranges, err := parseRangesList(rangesParam, loc)
-		return ranges, loc, err
+		ranges, err := parseRangesList(rangesParam)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range ranges {
+			ranges[i].Days = days
+		}
+		return append(ranges, minuteRanges...), loc, nil
 	}
 
 	// Fall back to start/end pairs format
@@ -66,270 +343,1883 @@ func parseTimeRangesFromQuery(r *http.Request) ([]TimeRange, *time.Location, err
 	endTimes := r.URL.Query()["end"]
 
 	if len(startTimes) != len(endTimes) {
-		return nil, nil, fmt.Errorf("mismatched start/end time pairs")
+		more := "start"
+		if len(endTimes) > len(startTimes) {
+			more = "end"
+		}
+		return nil, nil, fmt.Errorf("mismatched start/end time pairs: %d start times but %d end times (too many %s times)", len(startTimes), len(endTimes), more)
 	}
 
 	var ranges []TimeRange
 	for i := 0; i < len(startTimes); i++ {
-		start, err := parseTimeOfDay(startTimes[i], loc)
+		start, err := parseTimeOfDay(startTimes[i])
 		if err != nil {
 			return nil, nil, fmt.Errorf("invalid start time %s: %w", startTimes[i], err)
 		}
-		end, err := parseTimeOfDay(endTimes[i], loc)
+		end, err := parseTimeOfDay(endTimes[i])
 		if err != nil {
 			return nil, nil, fmt.Errorf("invalid end time %s: %w", endTimes[i], err)
 		}
-		ranges = append(ranges, TimeRange{Start: start, End: end})
+		if err := validateRangeOrder(start, end, startTimes[i]+"-"+endTimes[i]); err != nil {
+			return nil, nil, err
+		}
+		ranges = append(ranges, TimeRange{
+			Start:       start,
+			End:         end,
+			Days:        days,
+			WithSeconds: hasSecondsComponent(startTimes[i]) || hasSecondsComponent(endTimes[i]),
+		})
 	}
 
-	return ranges, loc, nil
+	return append(ranges, minuteRanges...), loc, nil
 }
 
-// parseRangesList parses a comma-separated list of time ranges
-// Format: "09:00-10:00,14:00-15:00" or "09:00-10:00, 14:00-15:00"
-func parseRangesList(rangesStr string, loc *time.Location) ([]TimeRange, error) {
-	var ranges []TimeRange
-	
-	// Split by comma
-	rangeStrings := strings.Split(rangesStr, ",")
-	
-	for _, rangeStr := range rangeStrings {
-		rangeStr = strings.TrimSpace(rangeStr)
-		if rangeStr == "" {
+// parseDaysList parses a comma-separated list of weekday names
+// Format: "mon,wed,fri" or "monday, wednesday"
+// Returns nil for an empty string, meaning all days
+func parseDaysList(daysStr string) ([]string, error) {
+	var days []string
+	for _, day := range strings.Split(daysStr, ",") {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if day == "" {
 			continue
 		}
-		
-		// Split by dash to get start and end
-		parts := strings.Split(rangeStr, "-")
-		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid range format: %s (expected HH:MM-HH:MM)", rangeStr)
-		}
-		
-		start, err := parseTimeOfDay(strings.TrimSpace(parts[0]), loc)
-		if err != nil {
-			return nil, fmt.Errorf("invalid start time in range %s: %w", rangeStr, err)
-		}
-		
-		end, err := parseTimeOfDay(strings.TrimSpace(parts[1]), loc)
-		if err != nil {
-			return nil, fmt.Errorf("invalid end time in range %s: %w", rangeStr, err)
+		if _, ok := weekdayNames[day]; !ok {
+			return nil, fmt.Errorf("invalid day: %s (expected mon, tue, wed, thu, fri, sat or sun)", day)
 		}
-		
-		ranges = append(ranges, TimeRange{Start: start, End: end})
+		days = append(days, day)
 	}
-	
-	return ranges, nil
+	return days, nil
 }
 
-// parseTimeOfDay parses a time string in HH:MM format in the specified timezone
-func parseTimeOfDay(timeStr string, loc *time.Location) (time.Time, error) {
-	parts := strings.Split(timeStr, ":")
-	if len(parts) != 2 {
-		return time.Time{}, fmt.Errorf("invalid time format, expected HH:MM")
+// expandWeekdayRanges turns a map of weekday to ranges into ranges restricted to that weekday,
+// so each event is matched against the ranges for its weekday in the filter timezone
+// Ranges are returned in weekday order (Sunday first) for a stable result
+func expandWeekdayRanges(weekdayRanges map[string][]TimeRange) ([]TimeRange, error) {
+	days := make([]string, 0, len(weekdayRanges))
+	for day, ranges := range weekdayRanges {
+		if _, ok := weekdayNames[strings.ToLower(strings.TrimSpace(day))]; !ok {
+			return nil, fmt.Errorf("invalid day in weekday_ranges: %s (expected mon, tue, wed, thu, fri, sat or sun)", day)
+		}
+		for _, r := range ranges {
+			if len(r.Days) > 0 {
+				return nil, fmt.Errorf("invalid weekday_ranges: ranges under %s take their day from the key and can't set days", day)
+			}
+		}
+		days = append(days, day)
 	}
+	sort.Slice(days, func(i, j int) bool {
+		di := weekdayNames[strings.ToLower(strings.TrimSpace(days[i]))]
+		dj := weekdayNames[strings.ToLower(strings.TrimSpace(days[j]))]
+		if di != dj {
+			return di < dj
+		}
+		return days[i] < days[j]
+	})
 
-	hour, err := strconv.Atoi(parts[0])
-	if err != nil || hour < 0 || hour > 23 {
-		return time.Time{}, fmt.Errorf("invalid hour: %s", parts[0])
+	var expanded []TimeRange
+	for _, day := range days {
+		for _, r := range weekdayRanges[day] {
+			r.Days = []string{strings.ToLower(strings.TrimSpace(day))}
+			expanded = append(expanded, r)
+		}
 	}
+	return expanded, nil
+}
 
-	minute, err := strconv.Atoi(parts[1])
-	if err != nil || minute < 0 || minute > 59 {
-		return time.Time{}, fmt.Errorf("invalid minute: %s", parts[1])
+// validateRangeDays checks that every day name on the given ranges is recognized
+func validateRangeDays(ranges []TimeRange) error {
+	for _, r := range ranges {
+		for _, day := range r.Days {
+			if _, ok := weekdayNames[strings.ToLower(strings.TrimSpace(day))]; !ok {
+				return fmt.Errorf("invalid day: %s (expected mon, tue, wed, thu, fri, sat or sun)", day)
+			}
+		}
 	}
+	return nil
+}
 
-	// Use today's date as a base, but we'll compare only time components
-	now := time.Now().In(loc)
-	return time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, loc), nil
+// validateRangeOrders applies validateRangeOrder to ranges given as JSON, comparing their times of day
+// as matching does, so a range from 23:00 one day to 01:00 the next is rejected like 23:00-01:00
+func validateRangeOrders(ranges []TimeRange) error {
+	for _, r := range ranges {
+		layout := "15:04"
+		if r.WithSeconds {
+			layout = "15:04:05"
+		}
+		start := rangeTimeOnDay(time.Time{}, r.Start, r.WithSeconds, time.UTC)
+		end := rangeTimeOnDay(time.Time{}, r.End, r.WithSeconds, time.UTC)
+		if err := validateRangeOrder(start, end, r.Start.Format(layout)+"-"+r.End.Format(layout)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// eventMatchesExactRange checks if an event has exact start/end times matching any filter range
-// Events are filtered out if their start time matches the filter start time and end time matches the filter end time
-// Filter ranges are treated as daily recurring blocks (e.g., 09:00-10:00 matches events starting at 09:00 and ending at 10:00 on any day)
-// Event times are converted to the filter timezone before comparison
-func eventMatchesExactRange(eventStart, eventEnd time.Time, filterRanges []TimeRange, filterLoc *time.Location) bool {
-	// Convert event times to the filter timezone
-	eventStartLocal := eventStart.In(filterLoc)
-	eventEndLocal := eventEnd.In(filterLoc)
-	
-	// Extract time components from event (in filter timezone)
-	eventStartHour := eventStartLocal.Hour()
-	eventStartMinute := eventStartLocal.Minute()
-	eventEndHour := eventEndLocal.Hour()
-	eventEndMinute := eventEndLocal.Minute()
+// validateRangeMatches checks the per-range match modes and normalizes them in place
+// Ranges without a match mode are left empty so they follow the request's mode
+func validateRangeMatches(ranges []TimeRange) error {
+	for i, r := range ranges {
+		if r.Match == "" {
+			continue
+		}
+		mode, err := parseMatchModeValue(r.Match)
+		if err != nil {
+			return err
+		}
+		ranges[i].Match = mode
+	}
+	return nil
+}
 
-	// Check if event matches any filter range exactly
-	for _, filterRange := range filterRanges {
-		filterStartHour := filterRange.Start.Hour()
-		filterStartMinute := filterRange.Start.Minute()
-		filterEndHour := filterRange.End.Hour()
-		filterEndMinute := filterRange.End.Minute()
-
-		// Check if event start/end times match filter start/end times exactly
-		if eventStartHour == filterStartHour &&
-			eventStartMinute == filterStartMinute &&
-			eventEndHour == filterEndHour &&
-			eventEndMinute == filterEndMinute {
+// rangeAppliesOnWeekday checks if a filter range is active on the given weekday
+// Ranges without days apply to every day
+func rangeAppliesOnWeekday(r TimeRange, weekday time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, day := range r.Days {
+		if weekdayNames[strings.ToLower(strings.TrimSpace(day))] == weekday {
 			return true
 		}
 	}
 	return false
 }
 
-// fetchCalendar fetches the ICS calendar from Google
-func fetchCalendar() ([]byte, error) {
-	calendarURL, err := getCalendarURL()
+// parseDateRangesFromQuery parses absolute date ranges from the date_ranges query parameter
+// Format: date_ranges=2024-07-01T00:00/2024-07-08T00:00,2024-12-24T00:00/2024-12-27T00:00
+func parseDateRangesFromQuery(r *http.Request, loc *time.Location) ([]DateRange, error) {
+	var dateRanges []DateRange
+	for _, rangeStr := range strings.Split(r.URL.Query().Get("date_ranges"), ",") {
+		rangeStr = strings.TrimSpace(rangeStr)
+		if rangeStr == "" {
+			continue
+		}
+
+		parts := strings.Split(rangeStr, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid date range format: %s (expected YYYY-MM-DDTHH:MM/YYYY-MM-DDTHH:MM)", rangeStr)
+		}
+
+		dateRange, err := parseDateRange(parts[0], parts[1], loc)
+		if err != nil {
+			return nil, err
+		}
+		dateRanges = append(dateRanges, dateRange)
+	}
+	return dateRanges, nil
+}
+
+// parseDateRange parses an absolute start/end pair in the specified timezone
+func parseDateRange(startStr, endStr string, loc *time.Location) (DateRange, error) {
+	start, err := parseDateTime(strings.TrimSpace(startStr), loc)
 	if err != nil {
-		return nil, err
+		return DateRange{}, fmt.Errorf("invalid date range start %s: %w", startStr, err)
 	}
-	resp, err := http.Get(calendarURL)
+	end, err := parseDateTime(strings.TrimSpace(endStr), loc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch calendar: %w", err)
+		return DateRange{}, fmt.Errorf("invalid date range end %s: %w", endStr, err)
 	}
-	defer resp.Body.Close()
+	if !end.After(start) {
+		return DateRange{}, fmt.Errorf("invalid date range %s/%s: start must be before end", startStr, endStr)
+	}
+	return DateRange{Start: start, End: end}, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+// parseEffectiveWindow parses the optional effective_from/effective_to bounds for time ranges
+// Either bound may be empty, leaving that side of the window open
+func parseEffectiveWindow(fromStr, toStr string, loc *time.Location) (time.Time, time.Time, error) {
+	var from, to time.Time
+	var err error
+	if fromStr != "" {
+		if from, err = parseDateTime(strings.TrimSpace(fromStr), loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid effective_from %s: %w", fromStr, err)
+		}
+	}
+	if toStr != "" {
+		if to, err = parseDateTime(strings.TrimSpace(toStr), loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid effective_to %s: %w", toStr, err)
+		}
 	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid effective window %s/%s: effective_from must be before effective_to", fromStr, toStr)
+	}
+	return from, to, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+// parseDateWindow parses the from/to bounds of the date window events must start in
+// Each bound is an absolute date (YYYY-MM-DD or YYYY-MM-DDTHH:MM) or an offset from the
+// start of today such as 30d, -2w or 12h; empty bounds are zero, meaning unbounded
+func parseDateWindow(fromStr, toStr string, loc *time.Location, today time.Time) (time.Time, time.Time, error) {
+	from, err := parseWindowBound("from", fromStr, loc, today)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return time.Time{}, time.Time{}, err
 	}
-
-	return body, nil
+	to, err := parseWindowBound("to", toStr, loc, today)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date window %s/%s: from must be before to", fromStr, toStr)
+	}
+	return from, to, nil
 }
 
-// filterCalendar filters events from the calendar based on time ranges
-// Returns the filtered calendar data, original event count, and filtered event count
-func filterCalendar(icsData []byte, filterRanges []TimeRange, filterLoc *time.Location) ([]byte, int, int, error) {
-	cal, err := ics.ParseCalendar(strings.NewReader(string(icsData)))
+// parseWindowBound parses one bound of the date window
+func parseWindowBound(name, value string, loc *time.Location, today time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := parseDateTime(value, loc); err == nil {
+		return t, nil
+	}
+	offset, err := parseDayOffset(value)
 	if err != nil {
-		return nil, 0, 0, fmt.Errorf("failed to parse calendar: %w", err)
+		return time.Time{}, fmt.Errorf("invalid %s: %s (expected YYYY-MM-DD, YYYY-MM-DDTHH:MM or an offset like 30d or -7d)", name, value)
 	}
+	return today.Add(offset), nil
+}
 
-	// Create a new calendar with filtered events
-	filteredCal := ics.NewCalendar()
-	
-	// Copy all calendar properties from original calendar
-	filteredCal.CalendarProperties = cal.CalendarProperties
-
-	originalCount := len(cal.Events())
-	filteredCount := 0
-
-	// Filter events
-	for _, event := range cal.Events() {
-		eventStart, err := event.GetStartAt()
-		if err != nil {
-			log.Printf("Warning: failed to get event start time: %v", err)
-			continue
-		}
-
-		eventEnd, err := event.GetEndAt()
+// parseDayOffset parses a signed offset in days (30d), weeks (-2w) or a Go duration (12h)
+func parseDayOffset(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") || strings.HasSuffix(value, "w") {
+		n, err := strconv.Atoi(value[:len(value)-1])
 		if err != nil {
-			log.Printf("Warning: failed to get event end time: %v", err)
-			continue
+			return 0, err
 		}
-
-		// If event matches any filter range exactly, skip it
-		if eventMatchesExactRange(eventStart, eventEnd, filterRanges, filterLoc) {
-			continue
+		offset := time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(value, "w") {
+			offset *= 7
 		}
-
-		// Add event to filtered calendar
-		filteredCal.AddVEvent(event)
-		filteredCount++
+		return offset, nil
 	}
+	return time.ParseDuration(value)
+}
 
-	// Serialize filtered calendar
-	return []byte(filteredCal.Serialize()), originalCount, filteredCount, nil
+// parseDateTime parses a date-time string in YYYY-MM-DDTHH:MM (or YYYY-MM-DD) format in the specified timezone
+func parseDateTime(dateTimeStr string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02T15:04", dateTimeStr, loc); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", dateTimeStr, loc); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date format, expected YYYY-MM-DDTHH:MM")
 }
 
-// handleFilter handles the /filter endpoint
-func handleFilter(w http.ResponseWriter, r *http.Request) {
-	var filterRanges []TimeRange
-	var filterLoc *time.Location = time.Local
+// parseMatchMode parses the match query parameter
+// Defaults to exact matching when the parameter is absent
+func parseMatchMode(r *http.Request) (string, error) {
+	return parseMatchModeValue(r.URL.Query().Get("match"))
+}
 
-	// Try to parse from JSON body first
-	if r.Method == http.MethodPost {
-		var req FilterRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err == nil {
-			filterRanges = req.TimeRanges
-			// For JSON, use local timezone by default
-			filterLoc = time.Local
-		}
+// parseMatchModeValue validates a match mode value, defaulting to exact when it is empty
+func parseMatchModeValue(value string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(value))
+	switch mode {
+	case "":
+		return matchExact, nil
+	case matchExact, matchOverlap, matchContain, matchStartOnly:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid match mode: %s (expected %s, %s, %s or %s)", mode, matchExact, matchOverlap, matchContain, matchStartOnly)
 	}
+}
 
-	// If no JSON body or parsing failed, try query parameters
-	if len(filterRanges) == 0 {
-		var err error
-		filterRanges, filterLoc, err = parseTimeRangesFromQuery(r)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
-			return
-		}
+// parseCombineMode parses the combine query parameter
+// Defaults to OR when the parameter is absent
+func parseCombineMode(r *http.Request) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("combine")))
+	switch mode {
+	case "":
+		return combineOr, nil
+	case combineOr, combineAnd:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid combine mode: %s (expected %s or %s)", mode, combineOr, combineAnd)
 	}
+}
+
+// parseInvert parses the invert query parameter
+// When true, matching events are kept and everything else is removed
+func parseInvert(r *http.Request) (bool, error) {
+	return parseBoolParam(r, "invert", false)
+}
 
-	// Fetch calendar
-	icsData, err := fetchCalendar()
+// parseBoolParam parses a boolean query parameter, returning defaultValue when it is absent
+func parseBoolParam(r *http.Request, name string, defaultValue bool) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.ParseBool(value)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch calendar: %v", err), http.StatusInternalServerError)
-		return
+		return false, fmt.Errorf("invalid %s value: %s (expected true or false)", name, value)
 	}
+	return parsed, nil
+}
 
-	// If no ranges, return original calendar and log count
-	if len(filterRanges) == 0 {
-		// Parse to get event count
-		cal, err := ics.ParseCalendar(strings.NewReader(string(icsData)))
-		if err == nil {
-			eventCount := len(cal.Events())
-			log.Printf("[%s] Request: no filters applied, returned %d events", r.RemoteAddr, eventCount)
-		}
-		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
-		w.Write(icsData)
-		return
+// parseCountParam parses a non-negative integer query parameter, returning nil when it is absent
+func parseCountParam(r *http.Request, name string) (*int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
 	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid %s value: %s (expected a non-negative integer)", name, value)
+	}
+	return &count, nil
+}
 
-	// Filter calendar
-	filteredData, originalCount, filteredCount, err := filterCalendar(icsData, filterRanges, filterLoc)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to filter calendar: %v", err), http.StatusInternalServerError)
-		return
+// parseDurationLimit parses a non-negative Go duration such as 15m or 2h
+// Returns nil for an empty value, meaning no limit
+func parseDurationLimit(name, value string) (*time.Duration, error) {
+	if value == "" {
+		return nil, nil
 	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return nil, fmt.Errorf("invalid %s value: %s (expected a non-negative duration like 15m or 2h)", name, value)
+	}
+	return &duration, nil
+}
 
-	// Log event counts
-	log.Printf("[%s] Request: filtered %d events -> %d events (removed %d)", 
-		r.RemoteAddr, originalCount, filteredCount, originalCount-filteredCount)
+// parseAllDayMode validates an all-day mode value
+// Defaults to keeping all-day events when the value is empty
+func parseAllDayMode(value string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(value))
+	switch mode {
+	case "":
+		return allDayKeep, nil
+	case allDayKeep, allDayDrop, allDayOnly:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid all_day mode: %s (expected %s, %s or %s)", value, allDayKeep, allDayDrop, allDayOnly)
+	}
+}
 
-	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
-	w.Write(filteredData)
+// parseTransparencyMode validates a transparency mode value
+// An empty value keeps events regardless of TRANSP
+func parseTransparencyMode(value string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(value))
+	switch mode {
+	case "", transparencyOpaque, transparencyTransparent:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid transparency: %s (expected %s or %s)", value, transparencyOpaque, transparencyTransparent)
+	}
 }
 
-// handleHealth provides a health check endpoint
-func handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+// parseRangesList parses a comma-separated list of time ranges
+// Format: "09:00-10:00,14:00-15:00" or "09:00-10:00, 14:00-15:00"
+// Tokens that aren't in HH:MM-HH:MM form are resolved as named presets (e.g. "morning,lunch")
+func parseRangesList(rangesStr string) ([]TimeRange, error) {
+	var ranges []TimeRange
+	
+	// Split by comma
+	rangeStrings := strings.Split(rangesStr, ",")
+	
+	for _, rangeStr := range rangeStrings {
+		rangeStr = strings.TrimSpace(rangeStr)
+		if rangeStr == "" {
+			continue
+		}
+		
+		// Resolve named presets to their configured range
+		if !strings.Contains(rangeStr, "-") {
+			preset, ok := lookupPreset(rangeStr)
+			if !ok {
+				return nil, fmt.Errorf("invalid range format: %s (expected HH:MM-HH:MM or a preset name)", rangeStr)
+			}
+			rangeStr = preset
+		}
+
+		timeRange, err := parseTimeRange(rangeStr)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, timeRange)
+	}
+	
+	return ranges, nil
 }
 
-func main() {
-	// Check that CALENDAR_URL is set
-	calendarURL, err := getCalendarURL()
+// parseTimeRange parses a single HH:MM-HH:MM range; seconds and am/pm times are accepted as in parseTimeOfDay
+func parseTimeRange(rangeStr string) (TimeRange, error) {
+	parts := strings.Split(rangeStr, "-")
+	if len(parts) != 2 {
+		return TimeRange{}, fmt.Errorf("invalid range format: %s (expected HH:MM-HH:MM)", rangeStr)
+	}
+
+	start, err := parseTimeOfDay(strings.TrimSpace(parts[0]))
 	if err != nil {
-		log.Fatalf("Configuration error: %v", err)
+		return TimeRange{}, fmt.Errorf("invalid start time in range %s: %w", rangeStr, err)
 	}
-	log.Printf("Using calendar URL: %s", calendarURL)
 
-	port := defaultPort
-	if p := getEnv("PORT", ""); p != "" {
-		port = p
+	end, err := parseTimeOfDay(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return TimeRange{}, fmt.Errorf("invalid end time in range %s: %w", rangeStr, err)
+	}
+	if err := validateRangeOrder(start, end, rangeStr); err != nil {
+		return TimeRange{}, err
 	}
 
-	http.HandleFunc("/filter", handleFilter)
-	http.HandleFunc("/health", handleHealth)
+	return TimeRange{
+		Start:       start,
+		End:         end,
+		WithSeconds: hasSecondsComponent(parts[0]) || hasSecondsComponent(parts[1]),
+	}, nil
+}
 
-	log.Printf("Starting calendar filter service on port %s", port)
-	log.Printf("Filter endpoint: http://localhost:%s/filter", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+// maxMinuteOfDay is the last minute of a day accepted by ranges_minutes (23:59)
+const maxMinuteOfDay = 24*60 - 1
+
+// parseMinuteRangesList parses a comma-separated list of minute-of-day ranges
+// Format: "540-600,840-900" for 09:00-10:00 and 14:00-15:00; each minute must be 0-1439
+func parseMinuteRangesList(rangesStr string) ([]TimeRange, error) {
+	var ranges []TimeRange
+	for _, rangeStr := range strings.Split(rangesStr, ",") {
+		rangeStr = strings.TrimSpace(rangeStr)
+		if rangeStr == "" {
+			continue
+		}
+
+		parts := strings.Split(rangeStr, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid minute range format: %s (expected START-END in minutes of the day, e.g. 540-600)", rangeStr)
+		}
+		var minutes [2]int
+		for i, part := range parts {
+			minute, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || minute < 0 || minute > maxMinuteOfDay {
+				return nil, fmt.Errorf("invalid minute in range %s: %s (expected 0-%d)", rangeStr, strings.TrimSpace(part), maxMinuteOfDay)
+			}
+			minutes[i] = minute
+		}
+
+		start := time.Date(2000, time.January, 1, minutes[0]/60, minutes[0]%60, 0, 0, time.UTC)
+		end := time.Date(2000, time.January, 1, minutes[1]/60, minutes[1]%60, 0, 0, time.UTC)
+		if err := validateRangeOrder(start, end, rangeStr); err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, TimeRange{Start: start, End: end})
+	}
+	return ranges, nil
+}
+
+// hasSecondsComponent checks if a time string includes seconds (HH:MM:SS)
+func hasSecondsComponent(timeStr string) bool {
+	return strings.Count(timeStr, ":") == 2
+}
+
+// validateRangeOrder rejects ranges whose start is not before their end, which would never match
+func validateRangeOrder(start, end time.Time, rangeStr string) error {
+	if !start.Before(end) {
+		return fmt.Errorf("invalid range %s: start time must be before end time", rangeStr)
+	}
+	return nil
+}
+
+// parseTimeOfDay parses a time string in HH:MM or HH:MM:SS format
+// 12-hour times with an am/pm suffix (e.g. "2:00pm", "9:30 AM", "2pm") are also accepted
+// Only the clock components of the result are meaningful: they are compared against event
+// times converted to the filter timezone, so the range itself carries no date or zone
+func parseTimeOfDay(timeStr string) (time.Time, error) {
+	timeStr = strings.TrimSpace(timeStr)
+
+	// Detect a 12-hour am/pm suffix
+	meridiem := ""
+	lower := strings.ToLower(timeStr)
+	if strings.HasSuffix(lower, "am") || strings.HasSuffix(lower, "pm") {
+		meridiem = lower[len(lower)-2:]
+		timeStr = strings.TrimSpace(timeStr[:len(timeStr)-2])
+		if !strings.Contains(timeStr, ":") {
+			timeStr += ":00"
+		}
+	}
+
+	parts := strings.Split(timeStr, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid time format, expected HH:MM, HH:MM:SS or H:MMam/pm")
+	}
+
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return time.Time{}, fmt.Errorf("invalid hour: %s", parts[0])
+	}
+
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return time.Time{}, fmt.Errorf("invalid minute: %s", parts[1])
+	}
+
+	second := 0
+	if len(parts) == 3 {
+		second, err = strconv.Atoi(parts[2])
+		if err != nil || second < 0 || second > 59 {
+			return time.Time{}, fmt.Errorf("invalid second: %s", parts[2])
+		}
+	}
+
+	// Convert 12-hour times to 24-hour: 12am is midnight and 12pm is noon
+	if meridiem != "" {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, fmt.Errorf("invalid hour for 12-hour time: %s", parts[0])
+		}
+		hour %= 12
+		if meridiem == "pm" {
+			hour += 12
+		}
+	}
+
+	// Pin the clock time to a fixed UTC date; building it on today's date in the filter
+	// timezone would shift times that fall in a daylight saving gap (02:30 on the spring
+	// transition becomes 01:30 in America/New_York) whenever a request arrives that day
+	return time.Date(2000, time.January, 1, hour, minute, second, 0, time.UTC), nil
+}
+
+// eventMatchesExactRange checks if an event has exact start/end times matching any filter range
+// Start and end may each differ from the range by up to tolerance
+// Event times are converted to the filter timezone before comparison
+func eventMatchesExactRange(eventStart, eventEnd time.Time, filterRanges []TimeRange, filterLoc *time.Location, tolerance time.Duration) bool {
+	eventStartLocal := eventStart.In(filterLoc)
+	eventEndLocal := eventEnd.In(filterLoc)
+
+	// Check if event matches any filter range exactly
+	for _, filterRange := range filterRanges {
+		// Skip ranges that don't apply on the event's weekday
+		if !rangeAppliesOnWeekday(filterRange, eventStartLocal.Weekday()) {
+			continue
+		}
+
+		// Check if event start/end times match filter start/end times
+		if timeOfDayWithin(eventStartLocal, filterRange.Start, filterRange.WithSeconds, tolerance) &&
+			timeOfDayWithin(eventEndLocal, filterRange.End, filterRange.WithSeconds, tolerance) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventMatchesRangeStart checks if an event starts exactly at any filter range's start, ignoring its end
+// This catches events that begin at a fixed time but vary in length
+// The start may differ from the range by up to tolerance
+func eventMatchesRangeStart(eventStart time.Time, filterRanges []TimeRange, filterLoc *time.Location, tolerance time.Duration) bool {
+	eventStartLocal := eventStart.In(filterLoc)
+
+	for _, filterRange := range filterRanges {
+		if !rangeAppliesOnWeekday(filterRange, eventStartLocal.Weekday()) {
+			continue
+		}
+		if timeOfDayWithin(eventStartLocal, filterRange.Start, filterRange.WithSeconds, tolerance) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeOfDayWithin reports whether t's time of day is within tolerance of a filter range boundary
+// Times are compared at minute precision unless withSeconds is set, and the difference wraps
+// around midnight so 23:59 is one minute from 00:00
+func timeOfDayWithin(t, timeOfDay time.Time, withSeconds bool, tolerance time.Duration) bool {
+	secondOfDay := func(t time.Time) int {
+		second := t.Hour()*3600 + t.Minute()*60
+		if withSeconds {
+			second += t.Second()
+		}
+		return second
+	}
+	const secondsPerDay = 24 * 60 * 60
+	diff := secondOfDay(t) - secondOfDay(timeOfDay)
+	if diff < 0 {
+		diff = -diff
+	}
+	if secondsPerDay-diff < diff {
+		diff = secondsPerDay - diff
+	}
+	return time.Duration(diff)*time.Second <= tolerance
+}
+
+// rangeTimeOnDay places the time of day of a filter range boundary on the given day
+// Seconds are only kept when withSeconds is set, matching minute-precision ranges otherwise
+func rangeTimeOnDay(day, timeOfDay time.Time, withSeconds bool, loc *time.Location) time.Time {
+	second := 0
+	if withSeconds {
+		second = timeOfDay.Second()
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), timeOfDay.Hour(), timeOfDay.Minute(), second, 0, loc)
+}
+
+// eventOverlapsRange checks if an event overlaps a daily recurring filter range on any day it spans
+// Events that only touch the range boundary (e.g. ending at 10:00 when the range starts at 10:00) do not overlap
+// Zero-length events overlap if their instant falls within [start, end) of the range
+// Event times are converted to the filter timezone before comparison
+func eventOverlapsRange(eventStart, eventEnd time.Time, r TimeRange, loc *time.Location) bool {
+	eventStartLocal := eventStart.In(loc)
+	eventEndLocal := eventEnd.In(loc)
+
+	// Check the range on every day the event touches
+	day := time.Date(eventStartLocal.Year(), eventStartLocal.Month(), eventStartLocal.Day(), 0, 0, 0, 0, loc)
+	for !day.After(eventEndLocal) {
+		rangeStart := rangeTimeOnDay(day, r.Start, r.WithSeconds, loc)
+		rangeEnd := rangeTimeOnDay(day, r.End, r.WithSeconds, loc)
+
+		// Ranges that end before they start never match
+		if !rangeEnd.After(rangeStart) {
+			return false
+		}
+
+		if !rangeAppliesOnWeekday(r, day.Weekday()) {
+			day = day.AddDate(0, 0, 1)
+			continue
+		}
+
+		if eventStartLocal.Equal(eventEndLocal) {
+			if !eventStartLocal.Before(rangeStart) && eventStartLocal.Before(rangeEnd) {
+				return true
+			}
+		} else if eventStartLocal.Before(rangeEnd) && rangeStart.Before(eventEndLocal) {
+			return true
+		}
+
+		day = day.AddDate(0, 0, 1)
+	}
+	return false
+}
+
+// eventContainedInRange checks if an event falls entirely within a daily recurring filter range
+// The range is anchored to the day the event starts, so events spanning midnight are never contained
+// Events that exactly equal the range boundaries are treated as contained
+// Event times are converted to the filter timezone before comparison
+func eventContainedInRange(eventStart, eventEnd time.Time, r TimeRange, loc *time.Location) bool {
+	eventStartLocal := eventStart.In(loc)
+	eventEndLocal := eventEnd.In(loc)
+
+	if !rangeAppliesOnWeekday(r, eventStartLocal.Weekday()) {
+		return false
+	}
+
+	rangeStart := rangeTimeOnDay(eventStartLocal, r.Start, r.WithSeconds, loc)
+	rangeEnd := rangeTimeOnDay(eventStartLocal, r.End, r.WithSeconds, loc)
+
+	return !eventStartLocal.Before(rangeStart) && !eventEndLocal.After(rangeEnd)
+}
+
+// eventMatchesRanges checks if an event matches any filter range
+// Each range is compared using its own match mode if it has one, otherwise the request's mode
+// tolerance only applies to the exact and start_only modes
+func eventMatchesRanges(eventStart, eventEnd time.Time, filterRanges []TimeRange, filterLoc *time.Location, mode string, tolerance time.Duration) bool {
+	for _, filterRange := range filterRanges {
+		if eventMatchesRange(eventStart, eventEnd, filterRange, filterLoc, rangeMatchMode(filterRange, mode), tolerance) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeMatchMode returns the match mode for a range: its own if set, otherwise the request's mode
+func rangeMatchMode(r TimeRange, mode string) string {
+	if r.Match != "" {
+		return r.Match
+	}
+	return mode
+}
+
+// eventMatchesRange checks if an event matches a single filter range using the given match mode
+func eventMatchesRange(eventStart, eventEnd time.Time, filterRange TimeRange, filterLoc *time.Location, mode string, tolerance time.Duration) bool {
+	switch mode {
+	case matchOverlap:
+		return eventOverlapsRange(eventStart, eventEnd, filterRange, filterLoc)
+	case matchContain:
+		return eventContainedInRange(eventStart, eventEnd, filterRange, filterLoc)
+	case matchStartOnly:
+		return eventMatchesRangeStart(eventStart, []TimeRange{filterRange}, filterLoc, tolerance)
+	default:
+		return eventMatchesExactRange(eventStart, eventEnd, []TimeRange{filterRange}, filterLoc, tolerance)
+	}
+}
+
+// eventInDateRange checks if an event starts within any absolute date range
+// Ranges include their start and exclude their end
+func eventInDateRange(eventStart time.Time, dateRanges []DateRange) bool {
+	for _, dateRange := range dateRanges {
+		if !eventStart.Before(dateRange.Start) && eventStart.Before(dateRange.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// inEffectiveWindow reports whether an event starts within the window the time ranges apply to
+func inEffectiveWindow(eventStart time.Time, criteria FilterOptions) bool {
+	if !criteria.EffectiveFrom.IsZero() && eventStart.Before(criteria.EffectiveFrom) {
+		return false
+	}
+	if !criteria.EffectiveTo.IsZero() && !eventStart.Before(criteria.EffectiveTo) {
+		return false
+	}
+	return true
+}
+
+// recurrenceLookahead bounds the search for an occurrence of a recurring event after a
+// date window with no end; rules that repeat at least yearly always occur within it
+const recurrenceLookahead = 366 * 24 * time.Hour
+
+// inDateWindow reports whether an event starts within the date window
+// Recurring events are in the window if any of their occurrences start within it; one whose
+// recurrence can't be expanded is kept rather than lost, and filtered by its first occurrence
+func inDateWindow(event *ics.VEvent, eventStart time.Time, criteria FilterOptions, overridden []time.Time) bool {
+	from, to := criteria.WindowFrom, criteria.WindowTo
+	startsInWindow := (from.IsZero() || !eventStart.Before(from)) && (to.IsZero() || eventStart.Before(to))
+	if startsInWindow || !isRecurringEvent(event) || isRecurrenceOverride(event) {
+		return startsInWindow
+	}
+	if !to.IsZero() && !eventStart.Before(to) {
+		return false
+	}
+	// The series started before the window; look for a later occurrence inside it
+	if to.IsZero() {
+		to = from.Add(recurrenceLookahead)
+	}
+	occurrences, err := recurrenceOccurrences(event, eventStart, from, to, overridden)
+	if err != nil {
+		log.Printf("Warning: failed to expand event %s, keeping it and matching by its first occurrence: %v", event.Id(), err)
+		return true
+	}
+	return len(occurrences) > 0
+}
+
+// fetchResult holds a fetched calendar and the upstream validators returned with it
+type fetchResult struct {
+	data         []byte
+	etag         string
+	lastModified string
+	// notModified is set when the upstream returned 304 for a conditional request
+	notModified bool
+}
+
+// statusError reports an unexpected upstream HTTP status
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.code)
+}
+
+// checkCalendarBody rejects upstream responses that aren't iCalendar data, such as the HTML
+// login page served for an expired share link. The body must start with BEGIN:VCALENDAR;
+// the Content-Type is only reported, since servers often label ICS as text/plain
+func checkCalendarBody(contentType string, body []byte) error {
+	if startsWithCalendar(body) {
+		return nil
+	}
+	if contentType == "" {
+		contentType = "none"
+	}
+	return fmt.Errorf("URL did not return a calendar (Content-Type: %s); check that the link hasn't expired", contentType)
+}
+
+// startsWithCalendar reports whether data begins with BEGIN:VCALENDAR, ignoring a byte order mark and leading whitespace
+func startsWithCalendar(data []byte) bool {
+	const prefix = "BEGIN:VCALENDAR"
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	return len(trimmed) >= len(prefix) && strings.EqualFold(string(trimmed[:len(prefix)]), prefix)
+}
+
+// getDurationEnv returns a positive duration from an environment variable, or defaultValue if it is not set
+func getDurationEnv(key string, defaultValue time.Duration) (time.Duration, error) {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid %s: %s (expected a duration like %s)", key, valueStr, defaultValue)
+	}
+	return value, nil
+}
+
+// getBoolEnv returns a boolean from an environment variable, or defaultValue if it is not set
+func getBoolEnv(key string, defaultValue bool) (bool, error) {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %s (expected true or false)", key, valueStr)
+	}
+	return value, nil
+}
+
+// getDefaultTimezone returns the default filter timezone from the DEFAULT_TZ environment variable
+// Returns the server's local timezone if DEFAULT_TZ is not set
+func getDefaultTimezone() (*time.Location, error) {
+	name := getEnv("DEFAULT_TZ", "")
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := loadTimezone(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEFAULT_TZ: %s (%w)", name, err)
+	}
+	return loc, nil
+}
+
+// getDefaultRanges returns the time ranges from the DEFAULT_RANGES environment variable
+// Uses the same format as the ranges parameter; returns nil if DEFAULT_RANGES is not set
+func getDefaultRanges() ([]TimeRange, error) {
+	rangesStr := getEnv("DEFAULT_RANGES", "")
+	if rangesStr == "" {
+		return nil, nil
+	}
+	ranges, err := parseRangesList(rangesStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEFAULT_RANGES: %w", err)
+	}
+	return ranges, nil
+}
+
+// calendarTooLargeError reports an upstream calendar larger than maxCalendarBytes
+func calendarTooLargeError() error {
+	return fmt.Errorf("calendar is larger than the %d byte limit (MAX_CALENDAR_BYTES)", maxCalendarBytes)
+}
+
+// getMaxCalendarBytes returns the upstream body size limit from the MAX_CALENDAR_BYTES environment variable
+func getMaxCalendarBytes() (int64, error) {
+	valueStr := getEnv("MAX_CALENDAR_BYTES", "")
+	if valueStr == "" {
+		return defaultMaxCalendarBytes, nil
+	}
+	value, err := strconv.ParseInt(valueStr, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid MAX_CALENDAR_BYTES: %s (expected a positive number of bytes)", valueStr)
+	}
+	return value, nil
+}
+
+// normalizeCalendarURL rewrites webcal:// and webcals:// subscribe links to https://
+func normalizeCalendarURL(calendarURL string) string {
+	lower := strings.ToLower(calendarURL)
+	for _, scheme := range []string{"webcals://", "webcal://"} {
+		if strings.HasPrefix(lower, scheme) {
+			return "https://" + calendarURL[len(scheme):]
+		}
+	}
+	return calendarURL
+}
+
+// fetchCalendar fetches the ICS calendar from the given URL
+// If etag or lastModified are set, the request is conditional and may return notModified
+// Network errors and 5xx responses are retried with exponential backoff and jitter, and once
+// a calendar keeps failing, fetchBreaker short-circuits further fetches for a cooldown
+// URLs that came from a request (rather than CALENDAR_URL or ALLOWED_HOSTS) may not reach internal addresses
+func fetchCalendar(calendarURL, etag, lastModified string) (fetchResult, error) {
+	calendarURL = normalizeCalendarURL(calendarURL)
+	if err := fetchBreaker.allow(calendarURL); err != nil {
+		return fetchResult{}, err
+	}
+	ctx := context.WithValue(context.Background(), guardFetchKey{}, needsAddressGuard(calendarURL))
+
+	var err error
+	backoff := fetchBackoff
+	for attempt := 1; attempt <= fetchAttempts; attempt++ {
+		var result fetchResult
+		result, err = fetchCalendarOnce(ctx, calendarURL, etag, lastModified)
+		if err == nil {
+			fetchBreaker.record(calendarURL, nil)
+			return result, nil
+		}
+		if !isRetryableFetchError(err) || attempt == fetchAttempts {
+			break
+		}
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Printf("Warning: calendar fetch attempt %d/%d failed, retrying in %s: %v", attempt, fetchAttempts, delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+		backoff *= 2
+	}
+
+	fetchErrorsTotal.Inc()
+	fetchBreaker.record(calendarURL, err)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fetchResult{}, fmt.Errorf("timed out fetching calendar after %s: %w", httpClient.Timeout, err)
+	}
+	return fetchResult{}, err
+}
+
+// isRetryableFetchError checks if a fetch failure is worth retrying (network errors and 5xx responses)
+func isRetryableFetchError(err error) bool {
+	if isBlockedAddressError(err) {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// fetchCalendarOnce performs a single upstream calendar request
+func fetchCalendarOnce(ctx context.Context, calendarURL, etag, lastModified string) (fetchResult, error) {
+	timer := prometheus.NewTimer(fetchDuration)
+	defer timer.ObserveDuration()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, calendarURL, nil)
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	// Credentials embedded in the URL take precedence; Go sends them as Basic Auth itself
+	if username, password, ok := calendarCredentials(calendarURL); ok && req.URL.User == nil {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("failed to fetch calendar: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && (etag != "" || lastModified != "") {
+		return fetchResult{notModified: true}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fetchResult{}, &statusError{code: resp.StatusCode}
+	}
+
+	// Read one byte past the limit so an oversized body is detected rather than truncated
+	if resp.ContentLength > maxCalendarBytes {
+		return fetchResult{}, calendarTooLargeError()
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCalendarBytes+1))
+	if err != nil {
+		return fetchResult{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(body)) > maxCalendarBytes {
+		return fetchResult{}, calendarTooLargeError()
+	}
+	if err := checkCalendarBody(resp.Header.Get("Content-Type"), body); err != nil {
+		return fetchResult{}, err
+	}
+
+	return fetchResult{
+		data:         body,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// hasFilters reports whether any matching rule is configured
+func hasFilters(criteria FilterOptions) bool {
+	return len(criteria.TimeRanges) > 0 ||
+		len(criteria.DateRanges) > 0 ||
+		len(criteria.TitleContains) > 0 ||
+		len(criteria.TitleRegex) > 0 ||
+		len(criteria.LocationContains) > 0 ||
+		len(criteria.DescContains) > 0 ||
+		len(criteria.Categories) > 0 ||
+		len(criteria.Classes) > 0 ||
+		len(criteria.EventTypes) > 0 ||
+		len(criteria.UIDs) > 0 ||
+		len(criteria.Has) > 0 ||
+		len(criteria.Missing) > 0 ||
+		criteria.MinAttendees != nil ||
+		criteria.MaxAttendees != nil ||
+		criteria.MinDuration != nil ||
+		criteria.MaxDuration != nil ||
+		(criteria.AllDay != "" && criteria.AllDay != allDayKeep) ||
+		criteria.Transparency != "" ||
+		criteria.Recurrence != "" ||
+		criteria.Dedupe ||
+		!criteria.WindowFrom.IsZero() ||
+		!criteria.WindowTo.IsZero()
+}
+
+// isAllDayEvent checks if an event's DTSTART is a DATE rather than a DATE-TIME value
+func isAllDayEvent(event *ics.VEvent) bool {
+	prop := event.GetProperty(ics.ComponentPropertyDtStart)
+	if prop == nil {
+		return false
+	}
+	if values, ok := prop.ICalParameters["VALUE"]; ok && len(values) > 0 {
+		return strings.EqualFold(values[0], "DATE")
+	}
+	return !strings.Contains(prop.Value, "T")
+}
+
+// parseRecurrenceMode validates a recurrence mode value
+// An empty value keeps both recurring and one-off events
+func parseRecurrenceMode(value string) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(value))
+	switch mode {
+	case "", recurrenceRecurring, recurrenceSingle:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid recurrence: %s (expected %s or %s)", value, recurrenceRecurring, recurrenceSingle)
+	}
+}
+
+// isPartOfSeries checks if an event is a recurring series (RRULE) or an overridden occurrence
+// of one (RECURRENCE-ID), so overrides stay with their series when filtering by recurrence
+func isPartOfSeries(event *ics.VEvent) bool {
+	return isRecurringEvent(event) || isRecurrenceOverride(event)
+}
+
+// isTransparentEvent checks if an event is marked TRANSP:TRANSPARENT
+// Events without TRANSP are opaque, per RFC 5545
+func isTransparentEvent(event *ics.VEvent) bool {
+	prop := event.GetProperty(ics.ComponentPropertyTransp)
+	return prop != nil && strings.EqualFold(strings.TrimSpace(prop.Value), string(ics.TransparencyTransparent))
+}
+
+// eventSummary returns the unescaped SUMMARY of an event, or an empty string if it has none
+func eventSummary(event *ics.VEvent) string {
+	return eventTextProperty(event, ics.ComponentPropertySummary)
+}
+
+// eventTextProperty returns the unescaped value of a text property, or an empty string if the event has none
+func eventTextProperty(event *ics.VEvent, property ics.ComponentProperty) string {
+	prop := event.GetProperty(property)
+	if prop == nil {
+		return ""
+	}
+	return ics.FromText(prop.Value)
+}
+
+// containsAnyFold checks if s contains any of the keywords, ignoring case
+func containsAnyFold(s string, keywords []string) bool {
+	s = strings.ToLower(s)
+	for _, keyword := range keywords {
+		if keyword != "" && strings.Contains(s, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// foldDiacritics strips accents and other combining marks, so "Café" becomes "Cafe"
+func foldDiacritics(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(b.String())
+}
+
+// descriptionContainsAny checks if an event's DESCRIPTION contains any of the keywords, ignoring case
+// With fold set, diacritics are stripped from the description; keywords are expected to be folded already
+// Runs of whitespace, including the line breaks of multi-line descriptions, compare as a single space
+func descriptionContainsAny(event *ics.VEvent, keywords []string, fold bool) bool {
+	if len(keywords) == 0 {
+		return false
+	}
+	description := strings.Join(strings.Fields(eventTextProperty(event, ics.ComponentPropertyDescription)), " ")
+	if fold {
+		description = foldDiacritics(description)
+	}
+	for _, keyword := range keywords {
+		if containsAnyFold(description, []string{strings.Join(strings.Fields(keyword), " ")}) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventCategories returns the values of all CATEGORIES properties on an event
+// Each property may hold several comma-separated categories
+func eventCategories(event *ics.VEvent) []string {
+	var categories []string
+	for _, prop := range event.Properties {
+		if prop.IANAToken != string(ics.ComponentPropertyCategories) {
+			continue
+		}
+		for _, category := range strings.Split(prop.Value, ",") {
+			if category = strings.TrimSpace(ics.FromText(category)); category != "" {
+				categories = append(categories, category)
+			}
+		}
+	}
+	return categories
+}
+
+// hasAnyCategory reports whether an event is tagged with any of the given categories, ignoring case
+func hasAnyCategory(event *ics.VEvent, categories []string) bool {
+	if len(categories) == 0 {
+		return false
+	}
+	for _, eventCategory := range eventCategories(event) {
+		for _, category := range categories {
+			if strings.EqualFold(eventCategory, category) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// defaultEventClass is the CLASS of events that don't set one, per RFC 5545
+const defaultEventClass = "PUBLIC"
+
+// eventClass returns an event's CLASS in upper case, or PUBLIC when it has none
+func eventClass(event *ics.VEvent) string {
+	prop := event.GetProperty(ics.ComponentPropertyClass)
+	if prop == nil || strings.TrimSpace(prop.Value) == "" {
+		return defaultEventClass
+	}
+	return strings.ToUpper(strings.TrimSpace(prop.Value))
+}
+
+// hasAnyClass reports whether an event's CLASS is one of the given classes, ignoring case
+func hasAnyClass(event *ics.VEvent, classes []string) bool {
+	class := eventClass(event)
+	for _, candidate := range classes {
+		if strings.EqualFold(candidate, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventProperties are the properties accepted by has and missing, from the ics library's property constants
+var eventProperties = []ics.Property{
+	ics.PropertyAttach, ics.PropertyAttendee, ics.PropertyCategories, ics.PropertyClass,
+	ics.PropertyColor, ics.PropertyComment, ics.PropertyContact, ics.PropertyCreated,
+	ics.PropertyDescription, ics.PropertyDtend, ics.PropertyDtstamp, ics.PropertyDtstart,
+	ics.PropertyDuration, ics.PropertyExdate, ics.PropertyExrule, ics.PropertyGeo,
+	ics.PropertyLastModified, ics.PropertyLocation, ics.PropertyOrganizer, ics.PropertyPriority,
+	ics.PropertyRdate, ics.PropertyRecurrenceId, ics.PropertyRelatedTo, ics.PropertyRequestStatus,
+	ics.PropertyResources, ics.PropertyRrule, ics.PropertySequence, ics.PropertyStatus,
+	ics.PropertySummary, ics.PropertyTransp, ics.PropertyUid, ics.PropertyUrl,
+}
+
+// isEventProperty reports whether name is a known event property or an extension (X-...) property
+// Extension properties are accepted since feeds such as Google's rely on them
+func isEventProperty(name string) bool {
+	if strings.HasPrefix(name, "X-") && len(name) > 2 {
+		return true
+	}
+	for _, property := range eventProperties {
+		if string(property) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePropertyNames validates the property names given to has or missing, returning them in upper case
+func parsePropertyNames(param string, values []string) ([]string, error) {
+	var names []string
+	for _, value := range splitList(values) {
+		name := strings.ToUpper(value)
+		if !isEventProperty(name) {
+			return nil, fmt.Errorf("invalid %s property: %s", param, value)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// hasAnyProperty reports whether an event has at least one of the named properties
+func hasAnyProperty(event *ics.VEvent, names []string) bool {
+	for _, name := range names {
+		if event.GetProperty(ics.ComponentProperty(name)) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// missingAnyProperty reports whether an event lacks at least one of the named properties
+func missingAnyProperty(event *ics.VEvent, names []string) bool {
+	for _, name := range names {
+		if event.GetProperty(ics.ComponentProperty(name)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUID reports whether an event's UID is exactly one of the given values
+func hasUID(event *ics.VEvent, uids []string) bool {
+	uid := event.Id()
+	for _, candidate := range uids {
+		if candidate == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits comma-separated values, dropping empty entries
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// compileRegexList compiles each pattern, returning an error naming the first invalid one
+func compileRegexList(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchesAnyRegex checks if s matches any of the compiled patterns
+func matchesAnyRegex(s string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventMatchesCriteria checks if an event matches any of the filter criteria
+// Each configured filter is active; with combine=and the event must match all active filters instead
+func eventMatchesCriteria(event *ics.VEvent, eventStart, eventEnd time.Time, criteria FilterOptions) bool {
+	// With Fold set, keyword filters compare event text with diacritics stripped
+	text := func(s string) string {
+		if criteria.Fold {
+			return foldDiacritics(s)
+		}
+		return s
+	}
+	summary := eventSummary(event)
+	filters := []struct {
+		active  bool
+		matches func() bool
+	}{
+		{len(criteria.TimeRanges) > 0, func() bool {
+			return inEffectiveWindow(eventStart, criteria) &&
+				eventMatchesRanges(eventStart, eventEnd, criteria.TimeRanges, criteria.Location, criteria.Match, criteria.Tolerance)
+		}},
+		{len(criteria.DateRanges) > 0, func() bool { return eventInDateRange(eventStart, criteria.DateRanges) }},
+		{len(criteria.TitleContains) > 0, func() bool { return containsAnyFold(text(summary), criteria.TitleContains) }},
+		{len(criteria.TitleRegex) > 0, func() bool { return matchesAnyRegex(summary, criteria.TitleRegex) }},
+		{len(criteria.LocationContains) > 0, func() bool {
+			return containsAnyFold(text(eventTextProperty(event, ics.ComponentPropertyLocation)), criteria.LocationContains)
+		}},
+		{len(criteria.DescContains) > 0, func() bool { return descriptionContainsAny(event, criteria.DescContains, criteria.Fold) }},
+		{len(criteria.Categories) > 0, func() bool { return hasAnyCategory(event, criteria.Categories) }},
+		{len(criteria.Classes) > 0, func() bool { return hasAnyClass(event, criteria.Classes) }},
+		{len(criteria.EventTypes) > 0, func() bool { return hasAnyEventType(event, criteria.EventTypes) }},
+		{len(criteria.UIDs) > 0, func() bool { return hasUID(event, criteria.UIDs) }},
+		{len(criteria.Has) > 0, func() bool { return hasAnyProperty(event, criteria.Has) }},
+		{len(criteria.Missing) > 0, func() bool { return missingAnyProperty(event, criteria.Missing) }},
+		{criteria.MinDuration != nil || criteria.MaxDuration != nil, func() bool {
+			return durationOutOfRange(eventStart, eventEnd, criteria.MinDuration, criteria.MaxDuration)
+		}},
+		{criteria.MinAttendees != nil || criteria.MaxAttendees != nil, func() bool {
+			return attendeeCountOutOfRange(event, criteria.MinAttendees, criteria.MaxAttendees)
+		}},
+	}
+
+	all := criteria.Combine == combineAnd
+	active := false
+	for _, filter := range filters {
+		if !filter.active {
+			continue
+		}
+		active = true
+		if filter.matches() != all {
+			return !all
+		}
+	}
+	return all && active
+}
+
+// attendeeCountOutOfRange checks if an event's ATTENDEE count falls outside [min, max]
+// Events without attendees count as zero
+func attendeeCountOutOfRange(event *ics.VEvent, min, max *int) bool {
+	if min == nil && max == nil {
+		return false
+	}
+	count := len(event.Attendees())
+	return (min != nil && count < *min) || (max != nil && count > *max)
+}
+
+// durationOutOfRange reports whether an event is shorter than min or longer than max
+// Zero-duration events (and malformed ones ending before they start) count as 0,
+// so any positive min removes them while a max alone always keeps them
+func durationOutOfRange(eventStart, eventEnd time.Time, min, max *time.Duration) bool {
+	if min == nil && max == nil {
+		return false
+	}
+	duration := eventEnd.Sub(eventStart)
+	if duration < 0 {
+		duration = 0
+	}
+	return (min != nil && duration < *min) || (max != nil && duration > *max)
+}
+
+// filterResult holds the outcome of filtering a calendar
+type filterResult struct {
+	// Calendar contains the surviving events and the original calendar properties
+	Calendar *ics.Calendar
+	Kept     []*ics.VEvent
+	Removed  []*ics.VEvent
+	// OriginalCount is the number of events before filtering
+	OriginalCount int
+	// ExcludedOccurrences counts recurring event occurrences removed via EXDATE
+	ExcludedOccurrences int
+	// Occurrences counts individual occurrences within the expansion window; nil unless expanding
+	Occurrences *OccurrenceCounts
+	// Unparseable counts events whose start or end couldn't be determined
+	Unparseable int
+	// Duplicates counts events dropped as repeats of an earlier event's UID
+	Duplicates int
+	// Warnings describe malformed properties met while filtering
+	Warnings []string
+}
+
+// filterCalendar parses the calendar data and filters its events based on the filter criteria
+func filterCalendar(icsData []byte, criteria FilterOptions) (filterResult, error) {
+	cal, err := parseCalendarData(icsData)
+	if err != nil {
+		return filterResult{}, fmt.Errorf("failed to parse calendar: %w", err)
+	}
+	return filterEvents(cal, criteria), nil
+}
+
+// parseCalendarData parses iCalendar data, rejecting calendars cut off inside a component
+// The ics library returns those without an error but with a nil component in place of the
+// unfinished one, which would panic when its properties are read
+func parseCalendarData(icsData []byte) (*ics.Calendar, error) {
+	cal, err := ics.ParseCalendar(bytes.NewReader(icsData))
+	if err != nil {
+		return nil, err
+	}
+	for _, component := range cal.Components {
+		if component == nil || reflect.ValueOf(component).IsNil() {
+			return nil, fmt.Errorf("calendar ends inside a component (missing END line)")
+		}
+	}
+	return cal, nil
+}
+
+// filterEvents filters cal with Filter and collects the kept and removed events for the handlers
+func filterEvents(cal *ics.Calendar, criteria FilterOptions) filterResult {
+	filtered, stats := Filter(cal, criteria)
+	return filterResult{
+		Calendar:            filtered,
+		Kept:                filtered.Events(),
+		Removed:             stats.Removed,
+		OriginalCount:       stats.Original,
+		ExcludedOccurrences: stats.ExcludedOccurrences,
+		Occurrences:         stats.Occurrences,
+		Unparseable:         stats.Unparseable,
+		Duplicates:          stats.Duplicates,
+		Warnings:            stats.Warnings,
+	}
+}
+
+// handleFilter handles the /filter endpoint
+func handleFilter(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	w, closeWriter := maybeGzip(w, r)
+	defer closeWriter()
+
+	criteria, err := parseFilterOptions(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	nocache, err := parseBoolParam(r, "nocache", false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	format, err := parseOutputFormat(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	dryRun, err := parseBoolParam(r, "dryrun", false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	verbose, err := parseBoolParam(r, "verbose", false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	privacy, err := parsePrivacyMode(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	mergeAdjacent, err := parseBoolParam(r, "merge_adjacent", false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	shift, err := parseShift(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	outLoc, err := parseOutputTimezone(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	page, err := parsePageOptions(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sortOrder, err := parseSortOrder(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	reportUnparseable, err := parseBoolParam(r, "report_unparseable", false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Labels for the output calendar; empty keeps the source calendar's own
+	calname := r.URL.Query().Get("calname")
+	prodid := r.URL.Query().Get("prodid")
+
+	// Use an uploaded calendar if one was posted, otherwise fetch the configured one
+	icsData, uploaded, err := readUploadedCalendar(w, r)
+	if err == nil && uploaded {
+		err = validateUploadedCalendar(icsData)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid calendar: %v", err), http.StatusBadRequest)
+		return
+	}
+	source := sourceUpload
+	if !uploaded {
+		calendarURL, err := requestCalendarURL(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid calendar: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		// Fetch calendar
+		icsData, source, err = loadCalendar(calendarURL, nocache)
+		if isBlockedAddressError(err) {
+			http.Error(w, fmt.Sprintf("Invalid calendar: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to fetch calendar: %v", err), http.StatusBadGateway)
+			return
+		}
+		setStaleHeader(w, source)
+
+		// Let subscribed clients poll with conditional GETs; POST bodies aren't part of the validator
+		if r.Method == http.MethodGet {
+			var upstreamLastModified string
+			if entry, _, ok := calCache.lookup(calendarURL); ok {
+				upstreamLastModified = entry.lastModified
+			}
+			etag := filterETag(r, icsData, criteria)
+			if checkNotModified(w, r, etag, filterLastModified(upstreamLastModified, criteria.Location)) {
+				log.Printf("[%s] Request: not modified (calendar from %s)", requestLabel(r), source)
+				return
+			}
+		}
+	}
+
+	// If no filters or output changes, return original calendar and log count
+	rewritesEvents := privacy != privacyNone || mergeAdjacent || shift != 0 || outLoc != nil || page.active() || sortOrder != ""
+	relabels := calname != "" || prodid != ""
+	if !hasFilters(criteria) && format == formatICS && !dryRun && !rewritesEvents && !relabels {
+		// Parse to get event count
+		cal, err := ics.ParseCalendar(strings.NewReader(string(icsData)))
+		if err == nil {
+			eventCount := len(cal.Events())
+			log.Printf("[%s] Request: no filters applied, returned %d events in %dms (calendar from %s)",
+				requestLabel(r), eventCount, time.Since(start).Milliseconds(), source)
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write(icsData)
+		return
+	}
+
+	// Filter calendar
+	result, err := filterCalendar(icsData, criteria)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to filter calendar: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logFilterCounts(r, criteria, result.OriginalCount, len(result.Kept), time.Since(start), source)
+	if !dryRun {
+		eventsRemovedTotal.Add(float64(len(result.Removed)))
+	}
+
+	// Privacy applies before the output format is chosen, so the grouped and dry-run listings,
+	// which also show removed events, never carry the details it strips
+	applyPrivacy(result.Kept, privacy)
+	applyPrivacy(result.Removed, privacy)
+
+	if dryRun {
+		writeDryRun(w, result, verbose)
+		return
+	}
+
+	if format == formatGrouped {
+		writeGrouped(w, result, criteria)
+		return
+	}
+
+	if mergeAdjacent {
+		mergeAdjacentEvents(&result)
+	}
+	if sortOrder == sortByStart {
+		sortKeptEvents(&result)
+	}
+	applyPageOptions(&result, page)
+	shiftEvents(result.Kept, shift)
+	if outLoc != nil {
+		convertEventsToTimezone(&result, outLoc, criteria.Location)
+	}
+
+	if format == formatFreeBusy {
+		writeFreeBusy(w, result, criteria.ExpandFrom, criteria.ExpandTo)
+		return
+	}
+
+	if reportUnparseable {
+		annotateUnparseable(result.Calendar, result.Unparseable, criteria.KeepUnparseable)
+	}
+	relabelCalendar(result.Calendar, calname, prodid)
+	writeFilterResult(w, result, format)
+}
+
+// logFilterCounts logs the event counts for a filtered request, with its duration and calendar source
+// source may be empty when the request combined several calendars
+// With JSON logging the counts are added to the structured request log instead
+func logFilterCounts(r *http.Request, criteria FilterOptions, originalCount, filteredCount int, elapsed time.Duration, source string) {
+	if stats := statsForRequest(r); stats != nil {
+		stats.counted = true
+		stats.originalCount = originalCount
+		stats.filteredCount = filteredCount
+		stats.source = source
+		stats.expand = criteria.Expand
+		stats.expandFrom = criteria.ExpandFrom
+		stats.expandTo = criteria.ExpandTo
+		return
+	}
+
+	if criteria.Expand {
+		log.Printf("[%s] Request: expanding recurring events from %s to %s",
+			requestLabel(r), criteria.ExpandFrom.Format("2006-01-02"), criteria.ExpandTo.Format("2006-01-02"))
+	}
+	timing := fmt.Sprintf("in %dms", elapsed.Milliseconds())
+	if source != "" {
+		timing += fmt.Sprintf(" (calendar from %s)", source)
+	}
+	if criteria.Invert {
+		log.Printf("[%s] Request: filtered %d events -> %d events (kept %d matching) %s",
+			requestLabel(r), originalCount, filteredCount, filteredCount, timing)
+	} else {
+		log.Printf("[%s] Request: filtered %d events -> %d events (removed %d) %s",
+			requestLabel(r), originalCount, filteredCount, originalCount-filteredCount, timing)
+	}
+}
+
+// allowMethods rejects requests using any other HTTP method with a 405 and an Allow header
+func allowMethods(handler http.HandlerFunc, methods ...string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				handler(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", allow)
+		http.Error(w, fmt.Sprintf("Method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHealth provides a health check endpoint
+// The response includes the current calendar cache state
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "OK",
+		"cache":  calCache.status(),
+	})
+}
+
+// handleReady reports whether every configured calendar can be loaded, for readiness probes
+// Fresh cache entries count as reachable without contacting the upstream; otherwise the
+// calendar is revalidated or fetched. Any failure returns 503. Error details are only
+// logged, since they can include the calendar URL
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	calendars := map[string]string{}
+	if configured, err := getCalendarURLs(); err == nil {
+		for _, name := range calendarNames(configured) {
+			_, source, err := loadCalendar(configured[name], false)
+			if err == nil && source == sourceStale {
+				err = fmt.Errorf("upstream unavailable, only a stale copy is cached")
+			}
+			if err != nil {
+				log.Printf("[%s] Readiness check failed for calendar %s: %v", requestLabel(r), name, err)
+				calendars[name] = "unreachable"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			calendars[name] = "OK"
+		}
+	}
+
+	overall := "OK"
+	if status != http.StatusOK {
+		overall = "unavailable"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    overall,
+		"calendars": calendars,
+	})
+}
+
+func main() {
+	if err := setupLogging(); err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	// CALENDAR_URL is optional since calendars can be uploaded to /filter, but must be valid when set
+	if os.Getenv("CALENDAR_URL") == "" {
+		log.Printf("Warning: no CALENDAR_URL configured; requests must pass a url parameter or upload a calendar")
+	} else {
+		calendars, err := getCalendarURLs()
+		if err != nil {
+			log.Fatalf("Configuration error: %v", err)
+		}
+		if url, ok := calendars[defaultCalendarName]; ok && len(calendars) == 1 {
+			log.Printf("Using calendar URL: %s", redactURL(url))
+		} else {
+			for _, name := range calendarNames(calendars) {
+				log.Printf("Using calendar %s: %s", name, redactURL(calendars[name]))
+			}
+		}
+	}
+
+	fetchTimeout, err := getDurationEnv("FETCH_TIMEOUT", defaultFetchTimeout)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	httpClient = newFetchClient(fetchTimeout)
+
+	maxCalendarBytes, err = getMaxCalendarBytes()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	defaultLocation, err = getDefaultTimezone()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	log.Printf("Default filter timezone: %s", defaultLocation)
+
+	defaultRanges, err = getDefaultRanges()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	if len(defaultRanges) > 0 {
+		log.Printf("Default ranges: %s", getEnv("DEFAULT_RANGES", ""))
+	}
+
+	allowedHosts = parseAllowedHosts(getEnv("ALLOWED_HOSTS", ""))
+	if len(allowedHosts) > 0 {
+		log.Printf("Per-request calendar URLs limited to hosts: %s", strings.Join(allowedHosts, ", "))
+	}
+
+	if err := reloadConfig(); err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	watchConfigReload()
+
+	cacheTTL, err := getCacheTTL()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	calCache = newCalendarCache(cacheTTL)
+	if cacheTTL > 0 {
+		log.Printf("Caching calendar for %s", cacheTTL)
+	}
+
+	serveStale, err = getBoolEnv("SERVE_STALE", true)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	if !serveStale {
+		log.Printf("Serving stale calendars disabled; failed fetches return an error")
+	}
+
+	port := defaultPort
+	if p := getEnv("PORT", ""); p != "" {
+		port = p
+	}
+
+	calendarUsername = getEnv("CALENDAR_USERNAME", "")
+	calendarPassword = getEnv("CALENDAR_PASSWORD", "")
+	if calendarUsername != "" {
+		calendars, _ := getCalendarURLs()
+		if _, ok := calendars[defaultCalendarName]; ok {
+			log.Printf("Using Basic Auth as %s for the configured calendar", calendarUsername)
+		} else {
+			log.Printf("Warning: CALENDAR_USERNAME only applies to a single CALENDAR_URL; set CALENDAR_USERNAME_<NAME> and CALENDAR_PASSWORD_<NAME> for named calendars")
+		}
+	}
+
+	authToken = getEnv("AUTH_TOKEN", "")
+	if authToken != "" {
+		log.Printf("Token authentication enabled")
+	}
+
+	trustProxy, err = getBoolEnv("TRUST_PROXY", false)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	if trustProxy {
+		log.Printf("Trusting X-Real-IP and X-Forwarded-For for client addresses")
+	}
+
+	breakerThreshold, err := getBreakerThreshold()
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	breakerCooldown, err := getDurationEnv("CIRCUIT_BREAKER_COOLDOWN", defaultBreakerCooldown)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	fetchBreaker = newCircuitBreaker(breakerThreshold, breakerCooldown)
+	if breakerThreshold > 0 {
+		log.Printf("Pausing fetches of a calendar for %s after %d consecutive failures", breakerCooldown, breakerThreshold)
+	}
+
+	corsOrigins = parseCORSOrigins(getEnv("CORS_ORIGINS", ""))
+	if len(corsOrigins) > 0 {
+		log.Printf("CORS enabled for origins: %s", strings.Join(corsOrigins, ", "))
+	}
+
+	debugConfig, err := getBoolEnv("DEBUG_CONFIG", false)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	if debugConfig {
+		log.Printf("Debug config endpoint enabled at /debug/config")
+	}
+
+	http.Handle("/filter", instrumentHandler("/filter", withCORS(allowMethods(requireToken(handleFilter), http.MethodGet, http.MethodPost))))
+	http.Handle("/merge", instrumentHandler("/merge", withCORS(requireToken(handleMerge))))
+	http.Handle("/count", instrumentHandler("/count", withCORS(requireToken(handleCount))))
+	http.Handle("/preview", instrumentHandler("/preview", withCORS(requireToken(handlePreview))))
+	http.Handle("/profiles", instrumentHandler("/profiles", withCORS(requireToken(handleProfiles))))
+	http.HandleFunc("/health", allowMethods(handleHealth, http.MethodGet))
+	http.HandleFunc("/ready", allowMethods(handleReady, http.MethodGet))
+	http.HandleFunc("/version", allowMethods(handleVersion, http.MethodGet))
+	if debugConfig {
+		http.Handle("/debug/config", instrumentHandler("/debug/config", requireToken(allowMethods(handleDebugConfig(port, fetchTimeout), http.MethodGet))))
+	}
+	http.Handle("/metrics", promhttp.Handler())
+
+	readTimeout, err := getDurationEnv("READ_TIMEOUT", defaultReadTimeout)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	writeTimeout, err := getDurationEnv("WRITE_TIMEOUT", defaultWriteTimeout)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	idleTimeout, err := getDurationEnv("IDLE_TIMEOUT", defaultIdleTimeout)
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+	log.Printf("Server timeouts: read %s, write %s, idle %s", readTimeout, writeTimeout, idleTimeout)
+	if writeTimeout < fetchTimeout*fetchAttempts {
+		log.Printf("Warning: WRITE_TIMEOUT (%s) is shorter than a fully retried upstream fetch (%d x %s)",
+			writeTimeout, fetchAttempts, fetchTimeout)
+	}
+
+	log.Printf("Starting calendar filter service %s (commit %s) on port %s", versionInfo.Version, versionInfo.Commit, port)
+	log.Printf("Filter endpoint: http://localhost:%s/filter", port)
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	// Stop accepting connections on SIGINT/SIGTERM and let in-flight requests finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Fatalf("Graceful shutdown failed: %v", err)
+	}
+	log.Printf("Server stopped")
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventMatchesRangeAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("America/New_York not available: %v", err)
+	}
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatalf("time.Parse(%q): %v", value, err)
+		}
+		return parsed
+	}
+
+	// Clocks go forward from 02:00 EST to 03:00 EDT on 2024-03-10 and back
+	// from 02:00 EDT to 01:00 EST on 2024-11-03
+	tests := []struct {
+		name       string
+		start, end string
+		rangeStart string
+		rangeEnd   string
+		mode       string
+		want       bool
+	}{
+		{"exact before spring forward", "2024-03-09T14:00:00Z", "2024-03-09T15:00:00Z", "09:00", "10:00", matchExact, true},
+		{"exact on spring forward", "2024-03-10T13:00:00Z", "2024-03-10T14:00:00Z", "09:00", "10:00", matchExact, true},
+		{"exact after spring forward", "2024-03-11T13:00:00Z", "2024-03-11T14:00:00Z", "09:00", "10:00", matchExact, true},
+		{"exact on spring forward ignores UTC clock", "2024-03-10T14:00:00Z", "2024-03-10T15:00:00Z", "09:00", "10:00", matchExact, false},
+		{"exact across the spring gap", "2024-03-10T06:30:00Z", "2024-03-10T07:30:00Z", "01:30", "03:30", matchExact, true},
+		{"exact on fall back", "2024-11-03T14:00:00Z", "2024-11-03T15:00:00Z", "09:00", "10:00", matchExact, true},
+		{"exact in first repeated hour", "2024-11-03T05:00:00Z", "2024-11-03T05:30:00Z", "01:00", "01:30", matchExact, true},
+		{"exact in second repeated hour", "2024-11-03T06:00:00Z", "2024-11-03T06:30:00Z", "01:00", "01:30", matchExact, true},
+		{"overlap on spring forward", "2024-03-10T13:30:00Z", "2024-03-10T14:30:00Z", "09:00", "10:00", matchOverlap, true},
+		{"overlap touching on spring forward", "2024-03-10T12:00:00Z", "2024-03-10T13:00:00Z", "09:00", "10:00", matchOverlap, false},
+		{"overlap across the spring gap", "2024-03-10T06:30:00Z", "2024-03-10T07:30:00Z", "01:00", "03:00", matchOverlap, true},
+		{"overlap after the spring gap", "2024-03-10T07:00:00Z", "2024-03-10T08:00:00Z", "01:00", "03:00", matchOverlap, false},
+		{"overlap on fall back", "2024-11-03T14:30:00Z", "2024-11-03T15:30:00Z", "09:00", "10:00", matchOverlap, true},
+		{"overlap touching on fall back", "2024-11-03T15:00:00Z", "2024-11-03T16:00:00Z", "09:00", "10:00", matchOverlap, false},
+		{"overlap in second repeated hour", "2024-11-03T06:30:00Z", "2024-11-03T06:45:00Z", "01:00", "02:00", matchOverlap, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mustTimeRange(t, tt.rangeStart, tt.rangeEnd)
+			got := eventMatchesRange(utc(tt.start), utc(tt.end), r, newYork, tt.mode, 0)
+			if got != tt.want {
+				t.Errorf("eventMatchesRange(%s-%s, %s-%s, %s) = %v, want %v",
+					tt.start, tt.end, tt.rangeStart, tt.rangeEnd, tt.mode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		input                string
+		hour, minute, second int
+	}{
+		{"00:00", 0, 0, 0},
+		{"09:30", 9, 30, 0},
+		{"23:59:59", 23, 59, 59},
+		{"12:00am", 0, 0, 0},
+		{"12am", 0, 0, 0},
+		{"12:30am", 0, 30, 0},
+		{"1:00am", 1, 0, 0},
+		{"11:59pm", 23, 59, 0},
+		{"12:00pm", 12, 0, 0},
+		{"12pm", 12, 0, 0},
+		{"12:30 PM", 12, 30, 0},
+		{"2:00pm", 14, 0, 0},
+		{"9:30 AM", 9, 30, 0},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeOfDay(tt.input)
+		if err != nil {
+			t.Errorf("parseTimeOfDay(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got.Hour() != tt.hour || got.Minute() != tt.minute || got.Second() != tt.second {
+			t.Errorf("parseTimeOfDay(%q) = %s, want %02d:%02d:%02d", tt.input, got.Format("15:04:05"), tt.hour, tt.minute, tt.second)
+		}
+	}
+}
+
+func TestParseTimeOfDayRejectsInvalid(t *testing.T) {
+	for _, input := range []string{"", "24:00", "9", "09:60", "0:00am", "13:00pm", "12:00xm", "noon"} {
+		if got, err := parseTimeOfDay(input); err == nil {
+			t.Errorf("parseTimeOfDay(%q) = %s, want an error", input, got.Format("15:04:05"))
+		}
+	}
+}
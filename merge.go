@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// mergeCalendars combines the events of several calendars into one
+// Events sharing a UID are only included once, keeping the first occurrence
+// Other components (VTIMEZONE, VTODO, ...) are carried over, deduplicated by TZID or UID
+func mergeCalendars(cals []*ics.Calendar) *ics.Calendar {
+	merged := ics.NewCalendar()
+	seen := make(map[string]bool)
+
+	for _, cal := range cals {
+		for _, component := range cal.Components {
+			if id := componentIdentity(component); id != "" {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+			merged.Components = append(merged.Components, component)
+		}
+	}
+	return merged
+}
+
+// componentIdentity returns a key identifying a component across calendars, or "" if it has none
+// The component type is part of the key so an event and a task sharing a UID are both kept, and
+// so is any RECURRENCE-ID, since overridden occurrences share their series' UID (as in dedupeKey)
+func componentIdentity(component ics.Component) string {
+	var id, recurrenceID string
+	for _, property := range component.UnknownPropertiesIANAProperties() {
+		switch property.IANAToken {
+		case string(ics.PropertyUid), string(ics.PropertyTzid):
+			if id == "" {
+				if property.Value == "" {
+					return ""
+				}
+				id = property.Value
+			}
+		case string(ics.PropertyRecurrenceId):
+			recurrenceID = property.Value
+		}
+	}
+	if id == "" {
+		return ""
+	}
+	if recurrenceID != "" {
+		return fmt.Sprintf("%T:%s\x00%s", component, id, recurrenceID)
+	}
+	return fmt.Sprintf("%T:%s", component, id)
+}
+
+// fetchCalendarsConcurrently loads and parses each calendar URL in parallel
+// Calendars that fail to fetch or parse are logged and omitted; the result preserves URL order
+// stale reports whether any calendar was served from a stale cache entry
+func fetchCalendarsConcurrently(calendarURLs []string, bypassCache bool) (cals []*ics.Calendar, stale bool) {
+	results := make([]*ics.Calendar, len(calendarURLs))
+	staleResults := make([]bool, len(calendarURLs))
+
+	var wg sync.WaitGroup
+	for i, calendarURL := range calendarURLs {
+		wg.Add(1)
+		go func(i int, calendarURL string) {
+			defer wg.Done()
+
+			icsData, source, err := loadCalendar(calendarURL, bypassCache)
+			if err != nil {
+				log.Printf("Warning: failed to fetch calendar for merge: %v", err)
+				return
+			}
+			staleResults[i] = source == sourceStale
+			cal, err := parseCalendarData(icsData)
+			if err != nil {
+				log.Printf("Warning: failed to parse calendar for merge: %v", err)
+				return
+			}
+			results[i] = cal
+		}(i, calendarURL)
+	}
+	wg.Wait()
+
+	for i, cal := range results {
+		if cal != nil {
+			cals = append(cals, cal)
+			stale = stale || staleResults[i]
+		}
+	}
+	return cals, stale
+}
+
+// handleMerge handles the /merge endpoint
+// Calendars are taken from repeated url= parameters, or every configured calendar when none are given
+func handleMerge(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	w, closeWriter := maybeGzip(w, r)
+	defer closeWriter()
+
+	criteria, err := parseFilterOptions(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	nocache, err := parseBoolParam(r, "nocache", false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	privacy, err := parsePrivacyMode(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var calendarURLs []string
+	for _, requested := range r.URL.Query()["url"] {
+		calendarURL, err := validateCalendarURL(requested)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid calendar: %v", err), http.StatusBadRequest)
+			return
+		}
+		calendarURLs = append(calendarURLs, calendarURL)
+	}
+	if len(calendarURLs) == 0 {
+		if os.Getenv("CALENDAR_URL") == "" {
+			http.Error(w, "Invalid calendar: no calendars specified: pass url parameters (CALENDAR_URL is not configured)", http.StatusBadRequest)
+			return
+		}
+		calendars, err := getCalendarURLs()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to fetch calendar: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, name := range calendarNames(calendars) {
+			calendarURLs = append(calendarURLs, calendars[name])
+		}
+	}
+
+	cals, stale := fetchCalendarsConcurrently(calendarURLs, nocache)
+	if len(cals) == 0 {
+		http.Error(w, "Failed to fetch calendar: no calendars could be fetched", http.StatusBadGateway)
+		return
+	}
+	if stale {
+		setStaleHeader(w, sourceStale)
+	}
+
+	merged := mergeCalendars(cals)
+	result := filterEvents(merged, criteria)
+
+	log.Printf("[%s] Request: merged %d of %d calendars", requestLabel(r), len(cals), len(calendarURLs))
+	logFilterCounts(r, criteria, result.OriginalCount, len(result.Kept), time.Since(start), "")
+	eventsRemovedTotal.Add(float64(len(result.Removed)))
+
+	applyPrivacy(result.Kept, privacy)
+	relabelCalendar(result.Calendar, r.URL.Query().Get("calname"), r.URL.Query().Get("prodid"))
+	writeFilterResult(w, result, formatICS)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are registered with the default Prometheus registry and served on /metrics
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "calendar_filter_requests_total",
+		Help: "Total number of HTTP requests handled, by handler and status code.",
+	}, []string{"handler", "code"})
+
+	eventsRemovedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "calendar_filter_events_removed_total",
+		Help: "Total number of events removed by filters.",
+	})
+
+	fetchErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "calendar_fetch_errors_total",
+		Help: "Total number of upstream calendar fetches that failed after all retries.",
+	})
+
+	staleServedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "calendar_stale_served_total",
+		Help: "Total number of failed upstream fetches answered from a stale cached calendar.",
+	})
+
+	fetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "calendar_fetch_duration_seconds",
+		Help:    "Duration of individual upstream calendar fetch attempts.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// instrumentHandler counts requests to a handler by status code, tags them with a request ID,
+// and logs them when JSON logging is enabled
+func instrumentHandler(name string, handler http.HandlerFunc) http.Handler {
+	return withRequestID(logRequests(name, promhttp.InstrumentHandlerCounter(
+		requestsTotal.MustCurryWith(prometheus.Labels{"handler": name}),
+		handler,
+	)))
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Output formats for filtered calendars
+const (
+	// formatICS returns the filtered calendar as iCal
+	formatICS = "ics"
+	// formatJSON returns a JSON summary of kept and removed events
+	formatJSON = "json"
+	// formatFreeBusy returns a single VFREEBUSY of the kept events' busy periods
+	formatFreeBusy = "freebusy"
+	// formatGrouped returns JSON grouping every event by the time ranges it matches
+	formatGrouped = "grouped"
+)
+
+// EventSummary describes an event in JSON output
+// Start and End are omitted when they can't be parsed
+type EventSummary struct {
+	UID     string     `json:"uid"`
+	Summary string     `json:"summary"`
+	Start   *time.Time `json:"start,omitempty"`
+	End     *time.Time `json:"end,omitempty"`
+}
+
+// OccurrenceCounts counts individual event occurrences within the recurrence expansion window
+// Recurring events contribute one per occurrence, honoring EXDATE and RECURRENCE-ID overrides
+type OccurrenceCounts struct {
+	Original int `json:"original"`
+	Filtered int `json:"filtered"`
+}
+
+// FilterSummary is the JSON representation of a filter result
+// Occurrences is only included when recurring events are expanded
+type FilterSummary struct {
+	OriginalCount    int               `json:"original_count"`
+	FilteredCount    int               `json:"filtered_count"`
+	UnparseableCount int               `json:"unparseable_count"`
+	DuplicateCount   int               `json:"duplicate_count"`
+	Occurrences      *OccurrenceCounts `json:"occurrences,omitempty"`
+	Kept             []EventSummary    `json:"kept"`
+	Removed          []EventSummary    `json:"removed"`
+}
+
+// DryRunSummary is the JSON response for a dry run
+// Removed and Warnings are only included when verbose output is requested
+type DryRunSummary struct {
+	Original    int               `json:"original"`
+	WouldRemove int               `json:"would_remove"`
+	Unparseable int               `json:"unparseable"`
+	Duplicates  int               `json:"duplicates"`
+	Occurrences *OccurrenceCounts `json:"occurrences,omitempty"`
+	Removed     []EventSummary    `json:"removed,omitempty"`
+	Warnings    []string          `json:"warnings,omitempty"`
+}
+
+// parseOutputFormat parses the format query parameter
+// Defaults to iCal output when the parameter is absent
+func parseOutputFormat(r *http.Request) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	switch format {
+	case "":
+		return formatICS, nil
+	case formatICS, formatJSON, formatFreeBusy, formatGrouped:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid format: %s (expected %s, %s, %s or %s)", format, formatICS, formatJSON, formatFreeBusy, formatGrouped)
+	}
+}
+
+// summarizeEvent builds the JSON description of an event
+func summarizeEvent(event *ics.VEvent) EventSummary {
+	summary := EventSummary{
+		UID:     event.Id(),
+		Summary: eventSummary(event),
+	}
+	if start, err := event.GetStartAt(); err == nil {
+		summary.Start = &start
+	}
+	if end, err := eventEndAt(event); err == nil {
+		summary.End = &end
+	}
+	return summary
+}
+
+// summarizeEvents builds the JSON descriptions of a list of events
+func summarizeEvents(events []*ics.VEvent) []EventSummary {
+	summaries := make([]EventSummary, 0, len(events))
+	for _, event := range events {
+		summaries = append(summaries, summarizeEvent(event))
+	}
+	return summaries
+}
+
+// setOccurrenceHeaders reports occurrence counts in response headers when recurring events were expanded
+// It also reports how many events had unparseable times, how many parse warnings were collected
+// in all and how many duplicates were dropped, so clients can notice malformed feeds without reading logs
+func setOccurrenceHeaders(w http.ResponseWriter, result filterResult) {
+	if result.Unparseable > 0 {
+		w.Header().Set("X-Unparseable-Count", strconv.Itoa(result.Unparseable))
+	}
+	if len(result.Warnings) > 0 {
+		w.Header().Set("X-Parse-Warnings", strconv.Itoa(len(result.Warnings)))
+	}
+	if result.Duplicates > 0 {
+		w.Header().Set("X-Duplicates-Removed", strconv.Itoa(result.Duplicates))
+	}
+	if result.Occurrences == nil {
+		return
+	}
+	w.Header().Set("X-Occurrences-Original", strconv.Itoa(result.Occurrences.Original))
+	w.Header().Set("X-Occurrences-Filtered", strconv.Itoa(result.Occurrences.Filtered))
+}
+
+// writeFilterResult writes a filter result in the requested output format
+func writeFilterResult(w http.ResponseWriter, result filterResult, format string) {
+	setOccurrenceHeaders(w, result)
+
+	switch format {
+	case formatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FilterSummary{
+			OriginalCount:    result.OriginalCount,
+			FilteredCount:    len(result.Kept),
+			UnparseableCount: result.Unparseable,
+			DuplicateCount:   result.Duplicates,
+			Occurrences:      result.Occurrences,
+			Kept:             summarizeEvents(result.Kept),
+			Removed:          summarizeEvents(result.Removed),
+		})
+	default:
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		writeCalendar(w, result.Calendar)
+	}
+}
+
+// relabelCalendar overwrites the calendar's X-WR-CALNAME and PRODID with calname and prodid,
+// so a filtered feed can be told apart from its source in calendar apps. Empty values keep the
+// originals; properties the source lacks are added. Values are escaped as TEXT (with carriage
+// returns, which the escaping leaves alone, removed), so line breaks can't inject properties
+func relabelCalendar(cal *ics.Calendar, calname, prodid string) {
+	text := func(value string) string {
+		return ics.ToText(strings.ReplaceAll(value, "\r", ""))
+	}
+	replacements := map[string]string{}
+	if calname != "" {
+		replacements[string(ics.PropertyXWRCalName)] = text(calname)
+	}
+	if prodid != "" {
+		replacements[string(ics.PropertyProductId)] = text(prodid)
+	}
+	if len(replacements) == 0 {
+		return
+	}
+
+	// Copy the properties, which are shared with the source calendar, before changing them
+	properties := make([]ics.CalendarProperty, 0, len(cal.CalendarProperties)+len(replacements))
+	for _, property := range cal.CalendarProperties {
+		if value, ok := replacements[property.IANAToken]; ok {
+			property.Value = value
+			delete(replacements, property.IANAToken)
+		}
+		properties = append(properties, property)
+	}
+	for _, token := range []ics.Property{ics.PropertyProductId, ics.PropertyXWRCalName} {
+		if value, ok := replacements[string(token)]; ok {
+			properties = append(properties, ics.CalendarProperty{BaseProperty: ics.BaseProperty{IANAToken: string(token), Value: value}})
+		}
+	}
+	cal.CalendarProperties = properties
+}
+
+// annotateUnparseable appends a note about events with unparseable times to the calendar's
+// X-WR-CALDESC, which calendar apps show as its description, so subscribers notice them
+// kept says whether those events were passed through or dropped; nothing is added when count is zero
+func annotateUnparseable(cal *ics.Calendar, count int, kept bool) {
+	if count == 0 {
+		return
+	}
+	outcome := "dropped"
+	if kept {
+		outcome = "passed through unfiltered"
+	}
+	note := fmt.Sprintf("Note: %d event(s) had times that could not be parsed and were %s.", count, outcome)
+
+	// Copy the properties, which are shared with the source calendar, before changing them
+	properties := make([]ics.CalendarProperty, 0, len(cal.CalendarProperties)+1)
+	found := false
+	for _, property := range cal.CalendarProperties {
+		if property.IANAToken == string(ics.PropertyXWRCalDesc) {
+			property.Value = strings.TrimSpace(property.Value + " " + note)
+			found = true
+		}
+		properties = append(properties, property)
+	}
+	cal.CalendarProperties = properties
+	if !found {
+		cal.SetXWRCalDesc(note)
+	}
+}
+
+// outputBufferSize is how much serialized calendar is buffered between writes to the client
+const outputBufferSize = 32 * 1024
+
+// writeCalendar streams a calendar to w component by component, rather than serializing
+// it to one string first, so output memory stays flat however large the calendar is
+func writeCalendar(w http.ResponseWriter, cal *ics.Calendar) {
+	buffered := bufio.NewWriterSize(w, outputBufferSize)
+	cal.SerializeTo(buffered)
+	buffered.Flush()
+}
+
+// writeDryRun writes the dry-run summary of a filter result
+func writeDryRun(w http.ResponseWriter, result filterResult, verbose bool) {
+	summary := DryRunSummary{
+		Original:    result.OriginalCount,
+		WouldRemove: len(result.Removed),
+		Unparseable: result.Unparseable,
+		Duplicates:  result.Duplicates,
+		Occurrences: result.Occurrences,
+	}
+	if verbose {
+		summary.Removed = summarizeEvents(result.Removed)
+		summary.Warnings = result.Warnings
+	}
+
+	setOccurrenceHeaders(w, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(summary)
+}
+
+// gzipResponseWriter compresses everything written to the underlying ResponseWriter
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (g *gzipResponseWriter) Write(data []byte) (int, error) {
+	return g.gz.Write(data)
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			// Ignore quality values such as gzip;q=0.8, except an explicit q=0 refusal
+			parts := strings.Split(encoding, ";")
+			if !strings.EqualFold(strings.TrimSpace(parts[0]), "gzip") {
+				continue
+			}
+			if len(parts) > 1 && strings.ReplaceAll(strings.TrimSpace(parts[1]), " ", "") == "q=0" {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// maybeGzip wraps the ResponseWriter in gzip compression when the client supports it
+// The returned close function must be called once the response has been written
+func maybeGzip(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return w, func() {}
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	return &gzipResponseWriter{ResponseWriter: w, gz: gz}, func() { gz.Close() }
+}
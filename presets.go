@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// defaultPresets are the built-in named time-of-day ranges usable in the ranges parameter
+var defaultPresets = map[string]string{
+	"morning":   "06:00-12:00",
+	"lunch":     "12:00-13:00",
+	"afternoon": "12:00-17:00",
+	"evening":   "17:00-21:00",
+}
+
+// rangePresets holds the named ranges in effect; it is replaced when the configuration is reloaded
+var (
+	presetsMu    sync.RWMutex
+	rangePresets = defaultPresets
+)
+
+// getPresets returns the named ranges in effect
+func getPresets() map[string]string {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	return rangePresets
+}
+
+// loadPresets returns the built-in presets merged with any overrides from the PRESETS environment variable
+// PRESETS is a JSON object mapping names to HH:MM-HH:MM ranges, e.g. {"focus": "09:00-11:00"}
+func loadPresets() (map[string]string, error) {
+	presets := make(map[string]string, len(defaultPresets))
+	for name, value := range defaultPresets {
+		presets[name] = value
+	}
+
+	presetsJSON := getEnv("PRESETS", "")
+	if presetsJSON == "" {
+		return presets, nil
+	}
+
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(presetsJSON), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid PRESETS JSON: %w", err)
+	}
+	for name, value := range overrides {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || strings.Contains(name, "-") {
+			return nil, fmt.Errorf("invalid preset name: %q", name)
+		}
+		// A preset stands in for a single range, so lists and other preset names are rejected
+		if _, err := parseTimeRange(value); err != nil {
+			return nil, fmt.Errorf("invalid preset %s: %w", name, err)
+		}
+		presets[name] = value
+	}
+	return presets, nil
+}
+
+// lookupPreset returns the range string for a named preset
+func lookupPreset(name string) (string, bool) {
+	value, ok := getPresets()[strings.ToLower(strings.TrimSpace(name))]
+	return value, ok
+}
+
+// resolvePresets replaces the preset names in a ranges list with their ranges from presets
+// Names that aren't in presets are left for parseRangesList to report
+func resolvePresets(rangesStr string, presets map[string]string) string {
+	entries := strings.Split(rangesStr, ",")
+	for i, entry := range entries {
+		if strings.Contains(entry, "-") {
+			continue
+		}
+		if value, ok := presets[strings.ToLower(strings.TrimSpace(entry))]; ok {
+			entries[i] = value
+		}
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPresetsRejectsInvalidValues(t *testing.T) {
+	for _, value := range []string{
+		"09:00-10:00,14:00-15:00",
+		"morning",
+		"10:00-09:00",
+		"9am",
+		"",
+	} {
+		t.Setenv("PRESETS", `{"focus": "`+value+`"}`)
+		if _, err := loadPresets(); err == nil || !strings.Contains(err.Error(), "invalid preset focus") {
+			t.Errorf("loadPresets with focus=%q: err = %v, want an invalid preset error", value, err)
+		}
+	}
+
+	t.Setenv("PRESETS", `{"Focus": "9:00am-11:00am"}`)
+	presets, err := loadPresets()
+	if err != nil {
+		t.Fatalf("loadPresets: %v", err)
+	}
+	if presets["focus"] != "9:00am-11:00am" {
+		t.Errorf("focus preset = %q, want 9:00am-11:00am", presets["focus"])
+	}
+}
+
+func TestReloadConfigRejectsListPresets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("presets:\n  focus: \"09:00-10:00,14:00-15:00\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+
+	previous := getPresets()
+	if err := reloadConfig(); err == nil || !strings.Contains(err.Error(), "invalid preset focus in config file") {
+		t.Errorf("reloadConfig: err = %v, want an invalid preset error", err)
+	}
+	if _, ok := getPresets()["focus"]; ok || len(getPresets()) != len(previous) {
+		t.Error("rejected presets were published")
+	}
+}
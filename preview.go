@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// previewTemplate renders the events kept by a filter as an HTML table
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Calendar preview</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+th { background: #f3f3f3; }
+</style>
+</head>
+<body>
+<h1>Calendar preview</h1>
+<p>{{.Kept}} of {{.Original}} events kept (times in {{.Location}})</p>
+<table>
+<tr><th>Date</th><th>Time</th><th>Summary</th></tr>
+{{- range .Rows}}
+<tr><td>{{.Date}}</td><td>{{.Time}}</td><td>{{.Summary}}</td></tr>
+{{- else}}
+<tr><td colspan="3">No events</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// previewRow is one event in the preview table
+type previewRow struct {
+	Date    string
+	Time    string
+	Summary string
+}
+
+// previewPage is the data rendered by previewTemplate
+type previewPage struct {
+	Original int
+	Kept     int
+	Location string
+	Rows     []previewRow
+}
+
+// previewEventRow formats an event's start and end for the preview table in loc
+func previewEventRow(event *ics.VEvent, loc *time.Location) previewRow {
+	row := previewRow{Summary: eventSummary(event)}
+	start, err := event.GetStartAt()
+	if err != nil {
+		row.Date = "?"
+		return row
+	}
+	if isAllDayEvent(event) {
+		// All-day dates are floating, so show them as written
+		row.Date = start.Format("Mon 2006-01-02")
+		row.Time = "All day"
+		return row
+	}
+	start = start.In(loc)
+	row.Date = start.Format("Mon 2006-01-02")
+	row.Time = start.Format("15:04")
+	if end, err := eventEndAt(event); err == nil {
+		row.Time += "–" + end.In(loc).Format("15:04")
+	}
+	return row
+}
+
+// handlePreview renders the events left after filtering as an HTML table, sorted by start
+// It accepts the same parameters as /filter, for checking a filter without a calendar app
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	criteria, err := parseFilterOptions(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	nocache, err := parseBoolParam(r, "nocache", false)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid filter parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	calendarURL, err := requestCalendarURL(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid calendar: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	icsData, source, err := loadCalendar(calendarURL, nocache)
+	if isBlockedAddressError(err) {
+		http.Error(w, fmt.Sprintf("Invalid calendar: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch calendar: %v", err), http.StatusBadGateway)
+		return
+	}
+	setStaleHeader(w, source)
+
+	result, err := filterCalendar(icsData, criteria)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to filter calendar: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logFilterCounts(r, criteria, result.OriginalCount, len(result.Kept), time.Since(start), source)
+
+	sortEventsByStart(result.Kept)
+	page := previewPage{
+		Original: result.OriginalCount,
+		Kept:     len(result.Kept),
+		Location: criteria.Location.String(),
+		Rows:     make([]previewRow, 0, len(result.Kept)),
+	}
+	for _, event := range result.Kept {
+		page.Rows = append(page.Rows, previewEventRow(event, criteria.Location))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := previewTemplate.Execute(w, page); err != nil {
+		log.Printf("[%s] Failed to render preview: %v", requestLabel(r), err)
+	}
+}
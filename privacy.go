@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// Privacy modes for surviving events
+const (
+	// privacyNone leaves events untouched
+	privacyNone = ""
+	// privacyBusy replaces titles with "Busy" and strips identifying details
+	privacyBusy = "busy"
+)
+
+// busySummary is the SUMMARY given to events in busy privacy mode
+const busySummary = "Busy"
+
+// privateProperties are removed from events in busy privacy mode
+var privateProperties = map[string]bool{
+	string(ics.ComponentPropertyDescription): true,
+	string(ics.ComponentPropertyLocation):    true,
+	string(ics.ComponentPropertyAttendee):    true,
+	string(ics.ComponentPropertyOrganizer):   true,
+	string(ics.PropertyComment):              true,
+	string(ics.ComponentPropertyUrl):         true,
+	string(ics.ComponentPropertyAttach):      true,
+	string(ics.PropertyContact):              true,
+	string(ics.ComponentPropertyCategories):  true,
+	string(ics.ComponentPropertyGeo):         true,
+	"X-ALT-DESC":                             true,
+}
+
+// parsePrivacyMode parses the privacy query parameter
+func parsePrivacyMode(r *http.Request) (string, error) {
+	mode := r.URL.Query().Get("privacy")
+	switch mode {
+	case privacyNone, privacyBusy:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid privacy mode: %s (expected busy)", mode)
+	}
+}
+
+// applyPrivacy strips details from events according to the privacy mode
+// Start/end times, recurrence rules and UIDs are kept so availability is preserved
+func applyPrivacy(events []*ics.VEvent, mode string) {
+	if mode != privacyBusy {
+		return
+	}
+	for _, event := range events {
+		properties := event.Properties[:0]
+		for _, prop := range event.Properties {
+			if !privateProperties[prop.IANAToken] {
+				properties = append(properties, prop)
+			}
+		}
+		event.Properties = properties
+		event.SetSummary(busySummary)
+
+		// Alarm descriptions usually repeat the event title
+		var components []ics.Component
+		for _, component := range event.Components {
+			if _, ok := component.(*ics.VAlarm); !ok {
+				components = append(components, component)
+			}
+		}
+		event.Components = components
+	}
+}
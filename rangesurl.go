@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rangesCacheTTL is how long a fetched ranges definition is reused before it is fetched again
+	rangesCacheTTL = time.Minute
+	// maxRangesBytes bounds a ranges definition; real ones are a few lines
+	maxRangesBytes = 64 << 10
+	// maxRangesCacheEntries bounds how many ranges definitions are cached, since any request
+	// can name a new ranges_url; the oldest entry is evicted to make room
+	maxRangesCacheEntries = 100
+)
+
+// rangesCacheEntry holds a fetched ranges definition and when it was fetched
+type rangesCacheEntry struct {
+	data      []byte
+	fetchedAt time.Time
+}
+
+// rangesCache holds fetched ranges definitions keyed by URL
+// The raw definition is cached rather than the parsed ranges, so preset changes apply immediately
+// Expired entries are removed when they are next looked up or when a new definition is stored
+var rangesCache = struct {
+	mu      sync.Mutex
+	entries map[string]rangesCacheEntry
+}{entries: make(map[string]rangesCacheEntry)}
+
+// parseRangesURL checks that a ranges_url is an absolute http(s) URL, without fetching it
+func parseRangesURL(raw string) (*url.URL, error) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ranges_url: %w", err)
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid ranges_url: %s (expected an http or https URL)", raw)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("invalid ranges_url: %s (missing host)", raw)
+	}
+	return parsed, nil
+}
+
+// validateRangesURL checks that a ranges_url is an absolute http(s) URL on a host permitted by ALLOWED_HOSTS
+func validateRangesURL(raw string) (string, error) {
+	parsed, err := parseRangesURL(raw)
+	if err != nil {
+		return "", err
+	}
+	if !hostAllowed(parsed.Hostname()) {
+		return "", fmt.Errorf("ranges_url host not allowed: %s (permitted hosts: %s)", parsed.Hostname(), strings.Join(allowedHosts, ", "))
+	}
+	return parsed.String(), nil
+}
+
+// loadRangesDefinition returns the ranges definition at rangesURL, from the cache while it is fresh
+// Concurrent requests for the same URL share a single fetch
+func loadRangesDefinition(rangesURL string) ([]byte, error) {
+	rangesCache.mu.Lock()
+	entry, ok := rangesCache.entries[rangesURL]
+	if ok && time.Since(entry.fetchedAt) > rangesCacheTTL {
+		delete(rangesCache.entries, rangesURL)
+		ok = false
+	}
+	rangesCache.mu.Unlock()
+	if ok {
+		return entry.data, nil
+	}
+
+	data, err, _ := fetchGroup.Do("ranges "+rangesURL, func() (interface{}, error) {
+		data, err := fetchRangesDefinition(rangesURL)
+		if err != nil {
+			return nil, err
+		}
+		storeRangesDefinition(rangesURL, data)
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// storeRangesDefinition caches a fetched ranges definition, first dropping expired entries
+// and, if the cache is still full, the oldest one
+func storeRangesDefinition(rangesURL string, data []byte) {
+	rangesCache.mu.Lock()
+	defer rangesCache.mu.Unlock()
+
+	now := time.Now()
+	oldestURL := ""
+	var oldest time.Time
+	for cachedURL, entry := range rangesCache.entries {
+		if now.Sub(entry.fetchedAt) > rangesCacheTTL {
+			delete(rangesCache.entries, cachedURL)
+			continue
+		}
+		if oldestURL == "" || entry.fetchedAt.Before(oldest) {
+			oldestURL, oldest = cachedURL, entry.fetchedAt
+		}
+	}
+	if _, ok := rangesCache.entries[rangesURL]; !ok && len(rangesCache.entries) >= maxRangesCacheEntries {
+		delete(rangesCache.entries, oldestURL)
+	}
+	rangesCache.entries[rangesURL] = rangesCacheEntry{data: data, fetchedAt: now}
+}
+
+// fetchRangesDefinition performs a single request for a ranges definition
+// Like per-request calendar URLs, it may not reach internal addresses unless the host is in ALLOWED_HOSTS
+func fetchRangesDefinition(rangesURL string) ([]byte, error) {
+	ctx := context.WithValue(context.Background(), guardFetchKey{}, needsAddressGuard(rangesURL))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rangesURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ranges_url: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch ranges_url: %w", &statusError{code: resp.StatusCode})
+	}
+
+	// Read one byte past the limit so an oversized body is detected rather than truncated
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRangesBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ranges_url: %w", err)
+	}
+	if len(body) > maxRangesBytes {
+		return nil, fmt.Errorf("ranges_url is larger than the %d byte limit", maxRangesBytes)
+	}
+	return body, nil
+}
+
+// parseRangesDefinition parses a fetched ranges definition
+// JSON is either a list of time ranges or an object with time_ranges, as in a POST body;
+// anything else is plain text in the ranges parameter format, with ranges separated by commas
+// or newlines and lines starting with # ignored
+func parseRangesDefinition(data []byte) ([]TimeRange, error) {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		var ranges []TimeRange
+		if trimmed[0] == '{' {
+			var definition struct {
+				TimeRanges []TimeRange `json:"time_ranges"`
+			}
+			if err := json.Unmarshal(trimmed, &definition); err != nil {
+				return nil, fmt.Errorf("invalid ranges_url JSON: %w", err)
+			}
+			ranges = definition.TimeRanges
+		} else if err := json.Unmarshal(trimmed, &ranges); err != nil {
+			return nil, fmt.Errorf("invalid ranges_url JSON: %w", err)
+		}
+		if err := validateRangeDays(ranges); err != nil {
+			return nil, err
+		}
+		if err := validateRangeOrders(ranges); err != nil {
+			return nil, err
+		}
+		if err := validateRangeMatches(ranges); err != nil {
+			return nil, err
+		}
+		return ranges, nil
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(trimmed), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+	ranges, err := parseRangesList(strings.Join(entries, ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ranges_url: %w", err)
+	}
+	return ranges, nil
+}
+
+// rangesFromURL fetches and parses the ranges definition named by a ranges_url parameter
+func rangesFromURL(raw string) ([]TimeRange, error) {
+	rangesURL, err := validateRangesURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	data, err := loadRangesDefinition(rangesURL)
+	if err != nil {
+		return nil, err
+	}
+	return parseRangesDefinition(data)
+}
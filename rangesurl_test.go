@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStoreRangesDefinitionEvicts(t *testing.T) {
+	rangesCache.mu.Lock()
+	rangesCache.entries = map[string]rangesCacheEntry{
+		"http://example.com/expired": {data: []byte("09:00-10:00"), fetchedAt: time.Now().Add(-2 * rangesCacheTTL)},
+	}
+	rangesCache.mu.Unlock()
+
+	for i := 0; i < maxRangesCacheEntries+10; i++ {
+		storeRangesDefinition(fmt.Sprintf("http://example.com/%d", i), []byte("09:00-10:00"))
+	}
+
+	rangesCache.mu.Lock()
+	defer rangesCache.mu.Unlock()
+	if len(rangesCache.entries) != maxRangesCacheEntries {
+		t.Errorf("cache holds %d entries, want %d", len(rangesCache.entries), maxRangesCacheEntries)
+	}
+	if _, ok := rangesCache.entries["http://example.com/expired"]; ok {
+		t.Error("expired entry was not evicted")
+	}
+	if _, ok := rangesCache.entries[fmt.Sprintf("http://example.com/%d", maxRangesCacheEntries+9)]; !ok {
+		t.Error("newest entry is missing")
+	}
+	rangesCache.entries = make(map[string]rangesCacheEntry)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// defaultExpandWindow is how far ahead recurring events are expanded when no window is given
+const defaultExpandWindow = 30 * 24 * time.Hour
+
+// componentPropertyRecurrenceID is the RECURRENCE-ID property, which the ics library doesn't define as a component property
+const componentPropertyRecurrenceID = ics.ComponentProperty(ics.PropertyRecurrenceId)
+
+// parseWindowDuration parses a window length such as "30d", "2w" or a Go duration like "72h"
+func parseWindowDuration(windowStr string) (time.Duration, error) {
+	windowStr = strings.TrimSpace(windowStr)
+	if windowStr == "" {
+		return 0, fmt.Errorf("window must not be empty")
+	}
+
+	var window time.Duration
+	switch {
+	case strings.HasSuffix(windowStr, "d") || strings.HasSuffix(windowStr, "w"):
+		n, err := strconv.Atoi(windowStr[:len(windowStr)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid window: %s (expected e.g. 30d, 2w or 72h)", windowStr)
+		}
+		window = time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(windowStr, "w") {
+			window *= 7
+		}
+	default:
+		var err error
+		window, err = time.ParseDuration(windowStr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window: %s (expected e.g. 30d, 2w or 72h)", windowStr)
+		}
+	}
+
+	if window <= 0 {
+		return 0, fmt.Errorf("invalid window: %s (must be positive)", windowStr)
+	}
+	return window, nil
+}
+
+// isRecurringEvent checks if an event has an RRULE
+func isRecurringEvent(event *ics.VEvent) bool {
+	return event.GetProperty(ics.ComponentPropertyRrule) != nil
+}
+
+// recurrenceOccurrences returns the start times of an event's occurrences within [from, to)
+// Existing EXDATE exclusions are honored, and overridden occurrences (those replaced by a
+// separate VEVENT with a matching RECURRENCE-ID) are skipped
+func recurrenceOccurrences(event *ics.VEvent, eventStart time.Time, from, to time.Time, overridden []time.Time) ([]time.Time, error) {
+	rruleProp := event.GetProperty(ics.ComponentPropertyRrule)
+	if rruleProp == nil {
+		return nil, fmt.Errorf("event has no RRULE")
+	}
+
+	option, err := rrule.StrToROptionInLocation(rruleProp.Value, eventStart.Location())
+	if err != nil {
+		return nil, fmt.Errorf("invalid RRULE %s: %w", rruleProp.Value, err)
+	}
+	option.Dtstart = eventStart
+	rule, err := rrule.NewRRule(*option)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RRULE %s: %w", rruleProp.Value, err)
+	}
+
+	set := &rrule.Set{}
+	set.RRule(rule)
+	for _, prop := range event.Properties {
+		if prop.IANAToken != string(ics.ComponentPropertyExdate) {
+			continue
+		}
+		exdates, err := parseDateList(prop, eventStart.Location())
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXDATE %s: %w", prop.Value, err)
+		}
+		for _, exdate := range exdates {
+			set.ExDate(exdate)
+		}
+	}
+
+	for _, override := range overridden {
+		set.ExDate(override)
+	}
+
+	return set.Between(from, to.Add(-time.Nanosecond), true), nil
+}
+
+// recurrenceOverrides maps each UID to the RECURRENCE-ID times of the events overriding its occurrences
+// A RECURRENCE-ID without a TZID or UTC suffix is read in the zone of its series' DTSTART, as
+// the occurrences it is matched against are, falling back to local time if the series is missing
+func recurrenceOverrides(events []*ics.VEvent) map[string][]time.Time {
+	seriesLocations := make(map[string]*time.Location)
+	for _, event := range events {
+		if !isRecurringEvent(event) || isRecurrenceOverride(event) {
+			continue
+		}
+		if start, err := event.GetStartAt(); err == nil {
+			seriesLocations[event.Id()] = start.Location()
+		}
+	}
+
+	overrides := make(map[string][]time.Time)
+	for _, event := range events {
+		prop := event.GetProperty(componentPropertyRecurrenceID)
+		if prop == nil {
+			continue
+		}
+		loc, ok := seriesLocations[event.Id()]
+		if !ok {
+			loc = time.Local
+		}
+		times, err := parseDateList(*prop, loc)
+		if err != nil || len(times) == 0 {
+			continue
+		}
+		overrides[event.Id()] = append(overrides[event.Id()], times[0])
+	}
+	return overrides
+}
+
+// isRecurrenceOverride checks if an event overrides a single occurrence of a recurring event
+func isRecurrenceOverride(event *ics.VEvent) bool {
+	return event.GetProperty(componentPropertyRecurrenceID) != nil
+}
+
+// parseDateList parses a comma-separated list of DATE or DATE-TIME values such as EXDATE
+// Values without a TZID parameter or UTC suffix are parsed in defaultLoc
+func parseDateList(prop ics.IANAProperty, defaultLoc *time.Location) ([]time.Time, error) {
+	loc := defaultLoc
+	if tzids, ok := prop.ICalParameters["TZID"]; ok && len(tzids) > 0 {
+		var err error
+		loc, err = time.LoadLocation(tzids[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var dates []time.Time
+	for _, value := range strings.Split(prop.Value, ",") {
+		value = strings.TrimSpace(value)
+		var t time.Time
+		var err error
+		switch {
+		case strings.HasSuffix(value, "Z"):
+			t, err = time.Parse("20060102T150405Z", value)
+		case strings.Contains(value, "T"):
+			t, err = time.ParseInLocation("20060102T150405", value, loc)
+		default:
+			t, err = time.ParseInLocation("20060102", value, loc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		dates = append(dates, t)
+	}
+	return dates, nil
+}
+
+// formatLikeDtStart formats an occurrence time the same way as the event's DTSTART
+// so that EXDATE values line up with the occurrences they exclude
+func formatLikeDtStart(dtstart *ics.IANAProperty, t time.Time) (string, []ics.PropertyParameter) {
+	if values, ok := dtstart.ICalParameters["VALUE"]; (ok && len(values) > 0 && strings.EqualFold(values[0], "DATE")) ||
+		!strings.Contains(dtstart.Value, "T") {
+		return t.Format("20060102"), []ics.PropertyParameter{&ics.KeyValues{Key: "VALUE", Value: []string{"DATE"}}}
+	}
+	if tzids, ok := dtstart.ICalParameters["TZID"]; ok && len(tzids) > 0 {
+		if loc, err := time.LoadLocation(tzids[0]); err == nil {
+			return t.In(loc).Format("20060102T150405"), []ics.PropertyParameter{&ics.KeyValues{Key: "TZID", Value: tzids}}
+		}
+	}
+	if strings.HasSuffix(dtstart.Value, "Z") {
+		return t.UTC().Format("20060102T150405Z"), nil
+	}
+	return t.Format("20060102T150405"), nil
+}
+
+// recurrenceOutcome describes the result of filtering a recurring event occurrence by occurrence
+type recurrenceOutcome struct {
+	// handled is false when the event couldn't be expanded and should be matched as a whole
+	handled bool
+	// removeEvent is set when the whole event should be dropped
+	removeEvent bool
+	// occurrences is the number of occurrences within the expansion window before filtering
+	occurrences int
+	// excluded is the number of occurrences removed via EXDATE
+	excluded int
+	// err is why the event couldn't be expanded, if it was malformed
+	err error
+}
+
+// filterRecurringEvent evaluates each occurrence of a recurring event within the expansion window
+// Occurrences that should be dropped are excluded by adding EXDATE properties to the event
+func filterRecurringEvent(event *ics.VEvent, eventStart, eventEnd time.Time, criteria FilterOptions, overridden []time.Time) recurrenceOutcome {
+	occurrences, err := recurrenceOccurrences(event, eventStart, criteria.ExpandFrom, criteria.ExpandTo, overridden)
+	if err != nil || len(occurrences) == 0 {
+		return recurrenceOutcome{err: err}
+	}
+
+	duration := eventEnd.Sub(eventStart)
+	var dropped []time.Time
+	for _, occurrence := range occurrences {
+		if eventMatchesCriteria(event, occurrence, occurrence.Add(duration), criteria) != criteria.Invert {
+			dropped = append(dropped, occurrence)
+		}
+	}
+
+	outcome := recurrenceOutcome{handled: true, occurrences: len(occurrences)}
+
+	// Every occurrence goes and the series as a whole matches too, so drop the event entirely
+	if len(dropped) == len(occurrences) &&
+		eventMatchesCriteria(event, eventStart, eventEnd, criteria) != criteria.Invert {
+		outcome.removeEvent = true
+		return outcome
+	}
+
+	dtstart := event.GetProperty(ics.ComponentPropertyDtStart)
+	for _, occurrence := range dropped {
+		value, params := formatLikeDtStart(dtstart, occurrence)
+		event.AddProperty(ics.ComponentPropertyExdate, value, params...)
+	}
+	outcome.excluded = len(dropped)
+	return outcome
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceOverridesUseSeriesTimezone(t *testing.T) {
+	if _, err := time.LoadLocation("America/New_York"); err != nil {
+		t.Skipf("America/New_York not available: %v", err)
+	}
+	cal := parseTestCalendar(t,
+		"UID:standup\nDTSTART;TZID=America/New_York:20240101T090000\nDTEND;TZID=America/New_York:20240101T091500\nRRULE:FREQ=DAILY;COUNT=5",
+		// A floating RECURRENCE-ID, as some clients write, names 09:00 in the series' zone
+		"UID:standup\nRECURRENCE-ID:20240103T090000\nDTSTART;TZID=America/New_York:20240103T100000\nDTEND;TZID=America/New_York:20240103T101500",
+		"UID:orphan\nRECURRENCE-ID:20240103T090000Z\nDTSTART:20240103T100000Z\nDTEND:20240103T101500Z",
+	)
+
+	overrides := recurrenceOverrides(cal.Events())
+
+	want := time.Date(2024, time.January, 3, 14, 0, 0, 0, time.UTC)
+	if got := overrides["standup"]; len(got) != 1 || !got[0].Equal(want) {
+		t.Errorf("standup overrides = %v, want [%s]", got, want)
+	}
+	want = time.Date(2024, time.January, 3, 9, 0, 0, 0, time.UTC)
+	if got := overrides["orphan"]; len(got) != 1 || !got[0].Equal(want) {
+		t.Errorf("orphan overrides = %v, want [%s]", got, want)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// shiftedProperties are the date-time properties moved by the shift parameter
+// Exclusions and overrides move with DTSTART so recurring series stay consistent
+var shiftedProperties = map[string]bool{
+	string(ics.ComponentPropertyDtStart):  true,
+	string(ics.ComponentPropertyDtEnd):    true,
+	string(ics.ComponentPropertyExdate):   true,
+	string(ics.ComponentPropertyRdate):    true,
+	string(componentPropertyRecurrenceID): true,
+}
+
+// parseShift parses the shift query parameter as a signed Go duration (e.g. +3h, -90m)
+func parseShift(r *http.Request) (time.Duration, error) {
+	value := strings.TrimSpace(r.URL.Query().Get("shift"))
+	if value == "" {
+		return 0, nil
+	}
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shift: %s (expected a duration like +3h or -90m)", value)
+	}
+	return offset, nil
+}
+
+// shiftEvents moves the absolute times of timed events by offset
+// All-day events are left alone since their dates have no time to shift
+func shiftEvents(events []*ics.VEvent, offset time.Duration) {
+	if offset == 0 {
+		return
+	}
+	for _, event := range events {
+		if isAllDayEvent(event) {
+			continue
+		}
+		for i := range event.Properties {
+			prop := &event.Properties[i]
+			if prop.IANAToken == string(ics.ComponentPropertyRrule) {
+				if err := shiftRRuleUntil(prop, event.GetProperty(ics.ComponentPropertyDtStart), offset); err != nil {
+					log.Printf("Warning: failed to shift RRULE UNTIL of event %s: %v", event.Id(), err)
+				}
+				continue
+			}
+			if !shiftedProperties[prop.IANAToken] {
+				continue
+			}
+			if err := shiftDateProperty(prop, offset); err != nil {
+				log.Printf("Warning: failed to shift %s of event %s: %v", prop.IANAToken, event.Id(), err)
+			}
+		}
+	}
+}
+
+// shiftDateProperty moves every date-time in a property value by offset, keeping its TZID/UTC/floating form
+func shiftDateProperty(prop *ics.IANAProperty, offset time.Duration) error {
+	times, err := parseDateList(*prop, time.Local)
+	if err != nil {
+		return err
+	}
+	values := make([]string, len(times))
+	for i, t := range times {
+		values[i], _ = formatLikeDtStart(prop, t.Add(offset))
+	}
+	prop.Value = strings.Join(values, ",")
+	return nil
+}
+
+// shiftRRuleUntil moves the UNTIL of an RRULE by offset, keeping its UTC, floating or date form,
+// so a bounded series doesn't lose its last occurrence once DTSTART has moved
+// Floating values are read in the zone of DTSTART, as the occurrences are. A date has no time to
+// shift, so it moves with the end of its day: every occurrence it covered is still covered
+func shiftRRuleUntil(prop *ics.IANAProperty, dtstart *ics.IANAProperty, offset time.Duration) error {
+	loc := time.Local
+	if dtstart != nil {
+		if tzids, ok := dtstart.ICalParameters["TZID"]; ok && len(tzids) > 0 {
+			var err error
+			loc, err = time.LoadLocation(tzids[0])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	parts := strings.Split(prop.Value, ";")
+	for i, part := range parts {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "UNTIL") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasSuffix(value, "Z"):
+			until, err := time.Parse("20060102T150405Z", value)
+			if err != nil {
+				return err
+			}
+			value = until.Add(offset).UTC().Format("20060102T150405Z")
+		case strings.Contains(value, "T"):
+			until, err := time.ParseInLocation("20060102T150405", value, loc)
+			if err != nil {
+				return err
+			}
+			value = until.Add(offset).In(loc).Format("20060102T150405")
+		default:
+			until, err := time.ParseInLocation("20060102", value, loc)
+			if err != nil {
+				return err
+			}
+			value = until.AddDate(0, 0, 1).Add(-time.Second).Add(offset).Format("20060102")
+		}
+		parts[i] = name + "=" + value
+	}
+	prop.Value = strings.Join(parts, ";")
+	return nil
+}
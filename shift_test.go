@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+func TestShiftEventsKeepsLastOccurrenceOfBoundedSeries(t *testing.T) {
+	cal := parseTestCalendar(t,
+		"UID:weekly\nDTSTART:20240101T220000Z\nDTEND:20240101T230000Z\nRRULE:FREQ=WEEKLY;UNTIL=20240122T220000Z")
+	event := cal.Events()[0]
+
+	shiftEvents(cal.Events(), 3*time.Hour)
+
+	if got := event.GetProperty(ics.ComponentPropertyRrule).Value; got != "FREQ=WEEKLY;UNTIL=20240123T010000Z" {
+		t.Errorf("RRULE = %s, want UNTIL moved by 3h in UTC form", got)
+	}
+	start, err := event.GetStartAt()
+	if err != nil {
+		t.Fatalf("GetStartAt: %v", err)
+	}
+	occurrences, err := recurrenceOccurrences(event, start, start, start.AddDate(0, 1, 0), nil)
+	if err != nil {
+		t.Fatalf("recurrenceOccurrences: %v", err)
+	}
+	want := time.Date(2024, time.January, 23, 1, 0, 0, 0, time.UTC)
+	if len(occurrences) != 4 || !occurrences[3].Equal(want) {
+		t.Errorf("occurrences after shift = %v, want 4 ending at %s", occurrences, want)
+	}
+}
+
+func TestShiftRRuleUntilKeepsForm(t *testing.T) {
+	tests := []struct {
+		name   string
+		event  string
+		offset time.Duration
+		want   string
+	}{
+		{"floating in DTSTART zone", "UID:a\nDTSTART;TZID=America/New_York:20240101T090000\nRRULE:FREQ=WEEKLY;UNTIL=20240122T090000",
+			2 * time.Hour, "FREQ=WEEKLY;UNTIL=20240122T110000"},
+		{"date", "UID:b\nDTSTART:20240101T220000Z\nRRULE:FREQ=DAILY;UNTIL=20240110;INTERVAL=2",
+			3 * time.Hour, "FREQ=DAILY;UNTIL=20240111;INTERVAL=2"},
+		{"no UNTIL", "UID:c\nDTSTART:20240101T220000Z\nRRULE:FREQ=DAILY;COUNT=3",
+			3 * time.Hour, "FREQ=DAILY;COUNT=3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := parseTestCalendar(t, tt.event).Events()[0]
+			rrule := event.GetProperty(ics.ComponentPropertyRrule)
+			if err := shiftRRuleUntil(rrule, event.GetProperty(ics.ComponentPropertyDtStart), tt.offset); err != nil {
+				t.Fatalf("shiftRRuleUntil: %v", err)
+			}
+			if rrule.Value != tt.want {
+				t.Errorf("RRULE = %s, want %s", rrule.Value, tt.want)
+			}
+		})
+	}
+}
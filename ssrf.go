@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// blockedAddressError is returned when a user-supplied calendar or ranges URL connects to an internal address
+type blockedAddressError struct {
+	address string
+}
+
+func (e *blockedAddressError) Error() string {
+	return fmt.Sprintf("URL resolves to a private or internal address (%s); add the host to ALLOWED_HOSTS to permit it", e.address)
+}
+
+// isBlockedAddressError reports whether err was caused by the internal address guard
+func isBlockedAddressError(err error) bool {
+	var blocked *blockedAddressError
+	return errors.As(err, &blocked)
+}
+
+// guardFetchKey marks a fetch context whose connections must not reach internal addresses
+type guardFetchKey struct{}
+
+// isInternalIP reports whether an IP is loopback, private, link-local or otherwise not publicly routable
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified()
+}
+
+// newFetchClient builds the HTTP client used for upstream calendar requests
+// Connections made with a guarded context are checked after DNS resolution, so redirects
+// and DNS rebinding to internal addresses are caught as well
+func newFetchClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	guardedDialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip != nil && isInternalIP(ip) {
+				return &blockedAddressError{address: host}
+			}
+			return nil
+		},
+	}
+
+	trusted := http.DefaultTransport.(*http.Transport).Clone()
+	trusted.DialContext = dialer.DialContext
+	guarded := http.DefaultTransport.(*http.Transport).Clone()
+	guarded.DialContext = guardedDialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: &fetchTransport{trusted: trusted, guarded: guarded}}
+}
+
+// fetchTransport sends guarded requests through their own transport
+// The guard only runs when a connection is dialed, so guarded requests must never reuse an idle
+// connection a trusted request opened to the same host; separate transports keep the pools apart
+type fetchTransport struct {
+	trusted *http.Transport
+	guarded *http.Transport
+}
+
+func (t *fetchTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if guarded, _ := req.Context().Value(guardFetchKey{}).(bool); guarded {
+		return t.guarded.RoundTrip(req)
+	}
+	return t.trusted.RoundTrip(req)
+}
+
+// needsAddressGuard reports whether fetching calendarURL must be kept away from internal addresses
+// Calendars configured in CALENDAR_URL and hosts listed explicitly in ALLOWED_HOSTS are trusted
+func needsAddressGuard(calendarURL string) bool {
+	if isConfiguredCalendarURL(calendarURL) {
+		return false
+	}
+	if len(allowedHosts) > 0 {
+		if parsed, err := url.Parse(calendarURL); err == nil && hostAllowed(parsed.Hostname()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGuardedFetchDoesNotReuseTrustedConnections(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")
+	}))
+	defer upstream.Close()
+
+	client := newFetchClient(5 * time.Second)
+	get := func(guarded bool) error {
+		ctx := context.WithValue(context.Background(), guardFetchKey{}, guarded)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		// Drain the body so the connection goes back to the idle pool
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil
+	}
+
+	// A trusted fetch, like a configured CALENDAR_URL, leaves a keep-alive connection to the loopback server
+	if err := get(false); err != nil {
+		t.Fatalf("trusted fetch failed: %v", err)
+	}
+	err := get(true)
+	if !isBlockedAddressError(err) {
+		t.Fatalf("guarded fetch to the same host: err = %v, want a blocked address error", err)
+	}
+}
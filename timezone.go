@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+)
+
+// vtimezoneYearsAhead is how far past today generated VTIMEZONE transitions extend,
+// so recurring events keep correct offsets into the future
+const vtimezoneYearsAhead = 10
+
+// timezoneAliases maps common abbreviations to the IANA zones users usually mean by them
+// Abbreviations like EST name fixed offsets in the tz database, so they are translated
+// before loading to pick up daylight saving time as well
+var timezoneAliases = map[string]string{
+	"EST": "America/New_York", "EDT": "America/New_York", "ET": "America/New_York",
+	"CST": "America/Chicago", "CDT": "America/Chicago", "CT": "America/Chicago",
+	"MST": "America/Denver", "MDT": "America/Denver", "MT": "America/Denver",
+	"PST": "America/Los_Angeles", "PDT": "America/Los_Angeles", "PT": "America/Los_Angeles",
+	"AKST": "America/Anchorage", "AKDT": "America/Anchorage",
+	"HST": "Pacific/Honolulu",
+	"BST": "Europe/London",
+	"CET": "Europe/Berlin", "CEST": "Europe/Berlin",
+	"EET": "Europe/Athens", "EEST": "Europe/Athens",
+	"IST":  "Asia/Kolkata",
+	"JST":  "Asia/Tokyo",
+	"AEST": "Australia/Sydney", "AEDT": "Australia/Sydney",
+	"NZST": "Pacific/Auckland", "NZDT": "Pacific/Auckland",
+	"Z": "UTC",
+}
+
+// commonTimezones are offered as suggestions when a timezone name isn't recognized
+var commonTimezones = []string{
+	"UTC",
+	"America/New_York", "America/Chicago", "America/Denver", "America/Phoenix",
+	"America/Los_Angeles", "America/Anchorage", "America/Toronto", "America/Vancouver",
+	"America/Mexico_City", "America/Sao_Paulo", "Pacific/Honolulu",
+	"Europe/London", "Europe/Dublin", "Europe/Paris", "Europe/Berlin", "Europe/Madrid",
+	"Europe/Rome", "Europe/Amsterdam", "Europe/Athens", "Europe/Moscow",
+	"Africa/Johannesburg", "Asia/Dubai", "Asia/Kolkata", "Asia/Singapore",
+	"Asia/Shanghai", "Asia/Hong_Kong", "Asia/Tokyo", "Asia/Seoul",
+	"Australia/Sydney", "Australia/Melbourne", "Australia/Perth", "Pacific/Auckland",
+}
+
+// loadTimezone loads an IANA timezone, also accepting the abbreviations in timezoneAliases
+// regardless of case. The error for an unknown name suggests similar common zones
+func loadTimezone(name string) (*time.Location, error) {
+	if alias, ok := timezoneAliases[strings.ToUpper(name)]; ok {
+		name = alias
+	}
+	loc, err := time.LoadLocation(name)
+	if err == nil {
+		return loc, nil
+	}
+	if suggestions := suggestTimezones(name); len(suggestions) > 0 {
+		return nil, fmt.Errorf("unknown timezone; did you mean %s?", strings.Join(suggestions, ", "))
+	}
+	return nil, fmt.Errorf("unknown timezone; expected an IANA name like America/New_York or an abbreviation like EST")
+}
+
+// suggestTimezones returns common zones resembling name, comparing case-insensitively
+// and treating spaces as underscores, so "new york" suggests America/New_York
+func suggestTimezones(name string) []string {
+	needle := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"))
+	if needle == "" {
+		return nil
+	}
+	var suggestions []string
+	for _, zone := range commonTimezones {
+		lower := strings.ToLower(zone)
+		city := lower[strings.LastIndex(lower, "/")+1:]
+		if strings.Contains(lower, needle) || strings.Contains(needle, city) {
+			suggestions = append(suggestions, zone)
+		}
+	}
+	return suggestions
+}
+
+// parseOutputTimezone parses the out_tz parameter; nil means times are left in their original zones
+// This only affects serialization; matching uses the tz parameter
+func parseOutputTimezone(r *http.Request) (*time.Location, error) {
+	name := strings.TrimSpace(r.URL.Query().Get("out_tz"))
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := loadTimezone(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid out_tz: %s (%w)", name, err)
+	}
+	if loc == time.Local {
+		return nil, fmt.Errorf("invalid out_tz: %s (expected an IANA timezone name)", name)
+	}
+	return loc, nil
+}
+
+// convertEventsToTimezone rewrites the date-times of every timed kept event into loc using TZID,
+// and adds a matching VTIMEZONE to the calendar. Floating times are read in floatingLoc
+// All-day events are left alone
+func convertEventsToTimezone(result *filterResult, loc, floatingLoc *time.Location) {
+	var earliest time.Time
+	for _, event := range result.Kept {
+		if isAllDayEvent(event) {
+			continue
+		}
+		for i := range event.Properties {
+			prop := &event.Properties[i]
+			if !shiftedProperties[prop.IANAToken] {
+				continue
+			}
+			times, err := parseDateList(*prop, floatingLoc)
+			if err != nil {
+				continue
+			}
+			values := make([]string, len(times))
+			for j, t := range times {
+				values[j] = t.In(loc).Format("20060102T150405")
+				if earliest.IsZero() || t.Before(earliest) {
+					earliest = t
+				}
+			}
+			prop.Value = strings.Join(values, ",")
+			if prop.ICalParameters == nil {
+				prop.ICalParameters = make(map[string][]string)
+			}
+			prop.ICalParameters["TZID"] = []string{loc.String()}
+		}
+	}
+	if earliest.IsZero() {
+		return
+	}
+
+	// Replace any existing definition of the zone with the generated one
+	components := result.Calendar.Components[:0]
+	for _, component := range result.Calendar.Components {
+		if tz, ok := component.(*ics.VTimezone); ok {
+			if tzid := tz.GetProperty(ics.ComponentProperty(ics.PropertyTzid)); tzid != nil && tzid.Value == loc.String() {
+				continue
+			}
+		}
+		components = append(components, component)
+	}
+	from := time.Date(earliest.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(time.Now().Year()+vtimezoneYearsAhead, time.January, 1, 0, 0, 0, 0, time.UTC)
+	result.Calendar.Components = append([]ics.Component{buildVTimezone(loc, from, to)}, components...)
+}
+
+// buildVTimezone describes loc between from and to as a VTIMEZONE with one
+// STANDARD or DAYLIGHT component per offset transition
+func buildVTimezone(loc *time.Location, from, to time.Time) *ics.VTimezone {
+	tz := &ics.VTimezone{}
+	tz.AddProperty(ics.ComponentProperty(ics.PropertyTzid), loc.String())
+
+	// The offset in effect at the start of the range, as a fixed initial observance
+	name, offset := from.In(loc).Zone()
+	tz.Components = append(tz.Components, timezoneObservance(from.In(loc), name, offset, offset, from.In(loc).IsDST()))
+
+	// Scan day by day for offset changes, then binary search for the exact instant
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		_, nextOffset := next.In(loc).Zone()
+		if nextOffset == offset {
+			continue
+		}
+		low, high := day, next
+		for high.Sub(low) > time.Second {
+			mid := low.Add(high.Sub(low) / 2)
+			if _, midOffset := mid.In(loc).Zone(); midOffset == offset {
+				low = mid
+			} else {
+				high = mid
+			}
+		}
+		transition := high.Truncate(time.Second)
+		nextName, _ := transition.In(loc).Zone()
+		// DTSTART is the wall-clock time of the transition in the offset being left
+		start := transition.In(time.FixedZone("", offset))
+		tz.Components = append(tz.Components, timezoneObservance(start, nextName, offset, nextOffset, transition.In(loc).IsDST()))
+		offset = nextOffset
+	}
+	return tz
+}
+
+// timezoneObservance builds a STANDARD or DAYLIGHT sub-component
+func timezoneObservance(start time.Time, name string, offsetFrom, offsetTo int, dst bool) ics.Component {
+	base := ics.ComponentBase{}
+	base.AddProperty(ics.ComponentProperty(ics.PropertyDtstart), start.Format("20060102T150405"))
+	base.AddProperty(ics.ComponentProperty(ics.PropertyTzoffsetfrom), formatUTCOffset(offsetFrom))
+	base.AddProperty(ics.ComponentProperty(ics.PropertyTzoffsetto), formatUTCOffset(offsetTo))
+	if name != "" {
+		base.AddProperty(ics.ComponentProperty(ics.PropertyTzname), name)
+	}
+	if dst {
+		return &ics.Daylight{ComponentBase: base}
+	}
+	return &ics.Standard{ComponentBase: base}
+}
+
+// formatUTCOffset formats an offset in seconds as +HHMM or -HHMM (with seconds when needed)
+func formatUTCOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	value := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+	if seconds%60 != 0 {
+		value += fmt.Sprintf("%02d", seconds%60)
+	}
+	return value
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// maxUploadSize caps the size of calendars uploaded to /filter
+const maxUploadSize = 10 << 20
+
+// uploadFormField is the multipart form field holding an uploaded calendar
+const uploadFormField = "calendar"
+
+// isCalendarUpload reports whether a request carries a calendar in its body
+// rather than a JSON filter request
+func isCalendarUpload(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/calendar" || mediaType == "multipart/form-data"
+}
+
+// readUploadedCalendar returns the calendar posted as a raw text/calendar body or as a
+// multipart file upload in the "calendar" field (or the first file when that field is absent)
+// ok is false when the request doesn't carry a calendar
+func readUploadedCalendar(w http.ResponseWriter, r *http.Request) (data []byte, ok bool, err error) {
+	if !isCalendarUpload(r) {
+		return nil, false, nil
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/calendar" {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to read uploaded calendar: %w", err)
+		}
+		return data, true, nil
+	}
+
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		return nil, true, fmt.Errorf("failed to parse multipart upload: %w", err)
+	}
+	files := r.MultipartForm.File[uploadFormField]
+	if len(files) == 0 {
+		for _, fieldFiles := range r.MultipartForm.File {
+			files = fieldFiles
+			break
+		}
+	}
+	if len(files) == 0 {
+		return nil, true, fmt.Errorf("multipart upload has no calendar file (expected a %q field)", uploadFormField)
+	}
+
+	file, err := files[0].Open()
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to open uploaded calendar: %w", err)
+	}
+	defer file.Close()
+	data, err = io.ReadAll(file)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read uploaded calendar: %w", err)
+	}
+	return data, true, nil
+}
+
+// validateUploadedCalendar rejects an uploaded body that isn't a complete calendar, so the client
+// gets a 400 rather than a failed filter, and nothing unchecked is echoed back when no filters apply
+func validateUploadedCalendar(data []byte) error {
+	if !startsWithCalendar(data) {
+		return fmt.Errorf("uploaded file is not an iCalendar file (expected it to start with BEGIN:VCALENDAR)")
+	}
+	if _, err := parseCalendarData(data); err != nil {
+		return fmt.Errorf("uploaded calendar could not be parsed: %w", err)
+	}
+	return nil
+}
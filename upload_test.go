@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFilterRejectsMalformedUploads(t *testing.T) {
+	uploads := map[string]string{
+		"not a calendar": "hello",
+		"truncated":      "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nDTSTART:2024",
+	}
+	// The filtered path parses the upload; the unfiltered one would otherwise echo it back as is
+	for _, query := range []string{"/filter?ranges=09:00-10:00", "/filter"} {
+		for name, body := range uploads {
+			r := httptest.NewRequest(http.MethodPost, query, strings.NewReader(body))
+			r.Header.Set("Content-Type", "text/calendar")
+			w := httptest.NewRecorder()
+
+			handleFilter(w, r)
+
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Invalid calendar") {
+				t.Errorf("%s with a %s upload: got %d %q, want 400 Invalid calendar", query, name, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestFilterAcceptsUploads(t *testing.T) {
+	body := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:standup\r\nDTSTART:20240105T090000Z\r\nDTEND:20240105T091500Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	for _, query := range []string{"/filter?ranges=10:00-11:00&tz=UTC", "/filter"} {
+		r := httptest.NewRequest(http.MethodPost, query, strings.NewReader(body))
+		r.Header.Set("Content-Type", "text/calendar")
+		w := httptest.NewRecorder()
+
+		handleFilter(w, r)
+
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "UID:standup") {
+			t.Errorf("%s: got %d %q, want the uploaded event back", query, w.Code, w.Body.String())
+		}
+	}
+}
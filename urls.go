@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// allowedHosts restricts the hosts that per-request calendar URLs may point at, configured in main
+// Entries are exact hostnames or "*.example.com" wildcards; when empty any host is accepted
+var allowedHosts []string
+
+// parseAllowedHosts parses ALLOWED_HOSTS as a comma-separated list of hostnames
+func parseAllowedHosts(raw string) []string {
+	var hosts []string
+	for _, host := range strings.Split(raw, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// hostAllowed reports whether a hostname matches the ALLOWED_HOSTS allowlist
+func hostAllowed(host string) bool {
+	if len(allowedHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, allowed := range allowedHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCalendarURL checks that a user-supplied calendar URL is an absolute http(s) or webcal URL
+// on a host permitted by ALLOWED_HOSTS
+func validateCalendarURL(raw string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fmt.Errorf("invalid calendar url: %w", err)
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "webcal", "webcals":
+	default:
+		return "", fmt.Errorf("invalid calendar url: %s (expected an http or https URL)", raw)
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("invalid calendar url: %s (missing host)", raw)
+	}
+	if !hostAllowed(parsed.Hostname()) {
+		return "", fmt.Errorf("calendar host not allowed: %s (permitted hosts: %s)", parsed.Hostname(), strings.Join(allowedHosts, ", "))
+	}
+	return parsed.String(), nil
+}
+
+// redactURL masks any password embedded in a URL so it can be logged
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return parsed.Redacted()
+}
+
+// requestCalendarURL returns the calendar URL to fetch for a request
+// A url parameter overrides CALENDAR_URL for the request; otherwise the cal parameter
+// selects one of the configured calendars
+func requestCalendarURL(r *http.Request) (string, error) {
+	if requested := r.URL.Query().Get("url"); requested != "" {
+		calendarURL, err := validateCalendarURL(requested)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("[%s] Request: using calendar URL from request: %s", requestLabel(r), redactURL(calendarURL))
+		return calendarURL, nil
+	}
+	if os.Getenv("CALENDAR_URL") == "" {
+		return "", fmt.Errorf("no calendar specified: pass a url parameter or upload a calendar (CALENDAR_URL is not configured)")
+	}
+
+	calendars, err := getCalendarURLs()
+	if err != nil {
+		return "", err
+	}
+	return resolveCalendarURL(calendars, r.URL.Query().Get("cal"))
+}
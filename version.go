@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+// -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.buildTime=2024-01-01T00:00:00Z"
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// VersionInfo is the body returned by /version
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+}
+
+// buildVersionInfo returns the build information, falling back to the VCS details Go
+// embeds in binaries built from a git checkout when -ldflags didn't set them
+// A value of "unknown", as older build scripts stamp by default, counts as unset
+func buildVersionInfo() VersionInfo {
+	info := VersionInfo{Version: version, Commit: commit, BuildTime: buildTime}
+	if info.Commit == "unknown" {
+		info.Commit = ""
+	}
+	if info.BuildTime == "unknown" {
+		info.BuildTime = ""
+	}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range buildInfo.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
+
+// versionInfo is computed once, since build information can't change while running
+var versionInfo = buildVersionInfo()
+
+// handleVersion returns the version, commit and build time of the running binary
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(versionInfo)
+}